@@ -0,0 +1,242 @@
+package attachments
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+)
+
+const (
+	// DefaultMaxDecompressedSize is the maximum number of bytes an
+	// attachment may expand to, across all of its members
+	DefaultMaxDecompressedSize = 64 << 20
+	// DefaultMaxEntries is the maximum number of members a zip attachment may have
+	DefaultMaxEntries = 32
+)
+
+var (
+	ErrUnknownFormat  = errors.New("attachment is not a gzip, zip, xml or json file")
+	ErrTooLarge       = errors.New("attachment exceeds the maximum decompressed size")
+	ErrTooManyEntries = errors.New("attachment exceeds the maximum number of zip entries")
+	ErrEmpty          = errors.New("attachment does not contain any report")
+)
+
+// Limits guards against decompression bombs
+type Limits struct {
+	MaxDecompressedSize int64
+	MaxEntries          int
+}
+
+// DefaultLimits are the limits used by the inputs unless configured otherwise
+var DefaultLimits = Limits{
+	MaxDecompressedSize: DefaultMaxDecompressedSize,
+	MaxEntries:          DefaultMaxEntries,
+}
+
+// Format is the container format of an attachment, detected by its magic bytes
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatGzip    Format = "gzip"
+	FormatZip     Format = "zip"
+	FormatXML     Format = "xml"
+	// FormatJSON is the format of SMTP TLS reports, RFC 8460
+	FormatJSON Format = "json"
+	// FormatMessage is an RFC 5322 message, e.g. a failure report saved as an .eml file.
+	// Its reports are read with FromMessage, Extract does not open it.
+	FormatMessage Format = "message"
+)
+
+// Payload is a single decompressed report extracted from an attachment
+type Payload struct {
+	Name string
+	Data []byte
+}
+
+// Detect returns the format of the data based on its leading bytes
+func Detect(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return FormatZip
+	case isJSON(data):
+		return FormatJSON
+	case isMessage(data):
+		return FormatMessage
+	case isXML(data):
+		return FormatXML
+	}
+
+	return FormatUnknown
+}
+
+// Extract returns the reports contained in an attachment, decompressing
+// gzip and zip containers. A zip may contain multiple reports.
+func Extract(name string, data []byte, limits Limits) ([]Payload, error) {
+	switch Detect(data) {
+	case FormatXML, FormatJSON:
+		if int64(len(data)) > limits.MaxDecompressedSize {
+			return nil, ErrTooLarge
+		}
+		return []Payload{{Name: name, Data: data}}, nil
+
+	case FormatGzip:
+		return extractGzip(name, data, limits)
+
+	case FormatZip:
+		return extractZip(data, limits)
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+func extractGzip(name string, data []byte, limits Limits) ([]Payload, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip: %w", err)
+	}
+	defer reader.Close()
+
+	content, err := readLimited(reader, limits.MaxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isReport(content) {
+		return nil, ErrUnknownFormat
+	}
+
+	// Prefer the original file name stored in the gzip header
+	if reader.Name != "" {
+		name = reader.Name
+	}
+
+	return []Payload{{Name: trimExt(name, ".gz"), Data: content}}, nil
+}
+
+func extractZip(data []byte, limits Limits) ([]Payload, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+
+	if len(reader.File) > limits.MaxEntries {
+		return nil, ErrTooManyEntries
+	}
+
+	payloads := []Payload{}
+	remaining := limits.MaxDecompressedSize
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		content, err := readZipFile(file, remaining)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= int64(len(content))
+
+		// Skip members that are not reports, like OS metadata files
+		if !isReport(content) {
+			continue
+		}
+
+		payloads = append(payloads, Payload{Name: path.Base(file.Name), Data: content})
+	}
+
+	if len(payloads) == 0 {
+		return nil, ErrEmpty
+	}
+
+	return payloads, nil
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	// The header can lie, but it lets us reject obvious bombs without reading them
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, ErrTooLarge
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip entry %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+
+	return readLimited(reader, limit)
+}
+
+// readLimited reads up to limit bytes, and fails if there is more to read
+func readLimited(reader io.Reader, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > limit {
+		return nil, ErrTooLarge
+	}
+
+	return content, nil
+}
+
+// isReport reports whether the data is a report, an XML aggregate report or a JSON TLS report
+func isReport(data []byte) bool {
+	format := Detect(data)
+
+	return format == FormatXML || format == FormatJSON
+}
+
+// isJSON reports whether the data looks like a JSON object,
+// ignoring a leading byte order mark and whitespace
+func isJSON(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, " \t\r\n")
+
+	return bytes.HasPrefix(data, []byte("{"))
+}
+
+// messageField matches the first header field of a message
+var messageField = regexp.MustCompile(`^[A-Za-z0-9-]+:[ \t]`)
+
+// isMessage reports whether the data looks like an RFC 5322 message,
+// starting with a header field. It is checked before isXML, as the first
+// bytes of a message can hold an XML declaration.
+func isMessage(data []byte) bool {
+	return messageField.Match(data)
+}
+
+// isXML reports whether the data looks like an XML document,
+// ignoring a leading byte order mark and whitespace. UTF-16 documents
+// are recognised by their byte order mark, and documents with garbage
+// before the XML declaration by the declaration in their first bytes.
+func isXML(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("\xff\xfe")) || bytes.HasPrefix(data, []byte("\xfe\xff")) {
+		return true
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.Contains(data[:min(len(data), sniffSize)], []byte("<?xml")) {
+		return true
+	}
+
+	data = bytes.TrimLeft(data, " \t\r\n")
+
+	return bytes.HasPrefix(data, []byte("<"))
+}
+
+func trimExt(name string, ext string) string {
+	if path.Ext(name) == ext {
+		return name[:len(name)-len(ext)]
+	}
+
+	return name
+}
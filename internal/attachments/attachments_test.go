@@ -0,0 +1,192 @@
+package attachments
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+const testReport = `<?xml version="1.0" encoding="UTF-8"?>
+<feedback><report_metadata><report_id>1</report_id></report_metadata></feedback>`
+
+func gzipData(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Name = name
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("failed to gzip: %s", err)
+	}
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+// zipData zips the files in the given order, as name and content pairs
+func zipData(t *testing.T, files ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for idx := 0; idx < len(files); idx += 2 {
+		file, err := writer.Create(files[idx])
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %s", err)
+		}
+		if _, err := file.Write([]byte(files[idx+1])); err != nil {
+			t.Fatalf("failed to zip: %s", err)
+		}
+	}
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]struct {
+		data     []byte
+		expected Format
+	}{
+		"xml":              {[]byte(testReport), FormatXML},
+		"xml with bom":     {[]byte("\xef\xbb\xbf  <feedback/>"), FormatXML},
+		"utf-16 xml":       {[]byte("\xff\xfe<\x00"), FormatXML},
+		"garbage then xml": {[]byte("garbage<?xml version=\"1.0\"?><feedback/>"), FormatXML},
+		"json":             {[]byte("\n{\"report-id\": \"1\"}"), FormatJSON},
+		"gzip":             {gzipData(t, "", []byte(testReport)), FormatGzip},
+		"zip":              {zipData(t, "report.xml", testReport), FormatZip},
+		"empty zip":        {[]byte("PK\x05\x06"), FormatZip},
+		"message":          {[]byte("From: dmarc@example.net\r\nSubject: <?xml in a subject\r\n"), FormatMessage},
+		"text":             {[]byte("This is an aggregate report"), FormatUnknown},
+		"empty":            {[]byte{}, FormatUnknown},
+	}
+
+	for name, test := range tests {
+		if format := Detect(test.data); format != test.expected {
+			t.Errorf("%s: expected format %q, got %q", name, test.expected, format)
+		}
+	}
+}
+
+func TestExtractGzip(t *testing.T) {
+	payloads, err := Extract("attachment.xml.gz", gzipData(t, "google.com!example.com!1!2.xml", []byte(testReport)), DefaultLimits)
+	if err != nil {
+		t.Fatalf("failed to extract: %s", err)
+	}
+
+	// The name stored in the gzip header is preferred
+	if len(payloads) != 1 || payloads[0].Name != "google.com!example.com!1!2.xml" || string(payloads[0].Data) != testReport {
+		t.Errorf("unexpected payloads: %+v", payloads)
+	}
+
+	payloads, err = Extract("report.xml.gz", gzipData(t, "", []byte(testReport)), DefaultLimits)
+	if err != nil {
+		t.Fatalf("failed to extract: %s", err)
+	}
+	if payloads[0].Name != "report.xml" {
+		t.Errorf("expected the attachment name without .gz, got %q", payloads[0].Name)
+	}
+
+	if _, err := Extract("text.gz", gzipData(t, "", []byte("not a report")), DefaultLimits); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected an unknown format error, got %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	data := zipData(t,
+		"reports/first.xml", testReport,
+		"__MACOSX/._first.xml", "\x00\x05\x16\x07",
+		"second.json", `{"report-id": "2"}`,
+	)
+
+	payloads, err := Extract("reports.zip", data, DefaultLimits)
+	if err != nil {
+		t.Fatalf("failed to extract: %s", err)
+	}
+
+	// Members that are not reports are skipped, and the paths are dropped
+	if len(payloads) != 2 || payloads[0].Name != "first.xml" || payloads[1].Name != "second.json" {
+		t.Errorf("unexpected payloads: %+v", payloads)
+	}
+
+	if _, err := Extract("empty.zip", zipData(t, "readme.txt", "no reports here"), DefaultLimits); !errors.Is(err, ErrEmpty) {
+		t.Errorf("expected an empty attachment error, got %v", err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	limits := Limits{MaxDecompressedSize: 1024, MaxEntries: 3}
+
+	// Highly compressible data, as in a decompression bomb
+	bomb := append([]byte("<feedback>"), bytes.Repeat([]byte(" "), 4096)...)
+
+	entries := []string{}
+	for idx := 0; idx < 4; idx++ {
+		entries = append(entries, fmt.Sprintf("report%d.xml", idx), testReport)
+	}
+
+	// Members are limited together, not each on its own
+	halves := []string{"first.xml", string(bomb[:800]), "second.xml", string(bomb[:800])}
+
+	tests := map[string]struct {
+		data     []byte
+		expected error
+	}{
+		"xml":                  {bomb, ErrTooLarge},
+		"gzip bomb":            {gzipData(t, "", bomb), ErrTooLarge},
+		"zip bomb":             {zipData(t, "report.xml", string(bomb)), ErrTooLarge},
+		"zip members together": {zipData(t, halves...), ErrTooLarge},
+		"zip entries":          {zipData(t, entries...), ErrTooManyEntries},
+	}
+
+	for name, test := range tests {
+		if _, err := Extract("attachment", test.data, limits); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, err)
+		}
+
+		// Streaming enforces the same limits on compressed attachments, plain
+		// reports are read from the file as they are
+		expected := test.expected
+		if Detect(test.data) == FormatXML {
+			expected = nil
+		}
+
+		err := ExtractStreams("attachment", bytes.NewReader(test.data), int64(len(test.data)), limits, func(stream Stream) error {
+			_, err := io.ReadAll(stream)
+			return err
+		})
+		if !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v while streaming, got %v", name, expected, err)
+		}
+	}
+}
+
+func TestExtractStreams(t *testing.T) {
+	data := zipData(t, "first.xml", testReport, "._first.xml", "\x00\x05", "second.xml.gz", string(gzipData(t, "", []byte(testReport))))
+
+	names := []string{}
+	err := ExtractStreams("reports.zip", bytes.NewReader(data), int64(len(data)), DefaultLimits, func(stream Stream) error {
+		content, err := io.ReadAll(stream)
+		if err != nil {
+			return err
+		}
+		if string(content) != testReport || stream.Format != FormatXML {
+			t.Errorf("unexpected stream %s: %q", stream.Name, content)
+		}
+
+		names = append(names, stream.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to extract: %s", err)
+	}
+
+	// Nested containers are not opened, like in Extract
+	if len(names) != 1 || names[0] != "first.xml" {
+		t.Errorf("expected only first.xml, got %v", names)
+	}
+}
@@ -0,0 +1,202 @@
+package attachments
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// maxMessageDepth limits how deep multipart and forwarded messages are walked
+const maxMessageDepth = 8
+
+// FailurePayload is a failure report (RFC 6591) found in a message
+type FailurePayload struct {
+	// Feedback holds the fields of the message/feedback-report part
+	Feedback []byte
+	// Original is the message that failed authentication, or only its headers
+	Original []byte
+}
+
+// MessageReports are the reports found in a message
+type MessageReports struct {
+	// Payloads are the aggregate report attachments, as sent
+	Payloads []Payload
+	// Failures are the failure reports
+	Failures []FailurePayload
+}
+
+// FromMessage returns the reports found in the MIME parts of an RFC 5322 message:
+// aggregate report attachments (xml, gzip or zip), returned as sent with their
+// transfer encoding removed so they can be passed to Extract, and failure reports
+// sent as multipart/report messages with a message/feedback-report part.
+func FromMessage(r io.Reader, limits Limits) (*MessageReports, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	walker := &messageWalker{remaining: limits.MaxDecompressedSize}
+	if err := walker.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, err
+	}
+
+	if len(walker.reports.Payloads) == 0 && len(walker.reports.Failures) == 0 {
+		return nil, ErrEmpty
+	}
+
+	return &walker.reports, nil
+}
+
+type messageWalker struct {
+	reports   MessageReports
+	remaining int64
+}
+
+func (w *messageWalker) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxMessageDepth {
+		return fmt.Errorf("message is nested more than %d levels deep", maxMessageDepth)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 default for parts without a (valid) content type
+		mediaType = "text/plain"
+	}
+
+	switch {
+	// Failure reports, RFC 5965 and RFC 6591
+	case mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "feedback-report"):
+		return w.walkFeedbackReport(body, params["boundary"])
+
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read multipart: %w", err)
+			}
+
+			if err := w.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+
+	// Reports forwarded as attached messages
+	case mediaType == "message/rfc822":
+		msg, err := mail.ReadMessage(body)
+		if err != nil {
+			return fmt.Errorf("failed to read attached message: %w", err)
+		}
+
+		return w.walk(textproto.MIMEHeader(msg.Header), msg.Body, depth+1)
+
+	// Message bodies, an html body would otherwise be mistaken for xml
+	case mediaType == "text/plain" || mediaType == "text/html":
+		if !isAttachment(header) {
+			return nil
+		}
+	}
+
+	content, err := readLimited(decodeTransferEncoding(header, body), w.remaining)
+	if err != nil {
+		return err
+	}
+
+	// Messages are only walked when attached as message/rfc822
+	if format := Detect(content); format == FormatUnknown || format == FormatMessage {
+		return nil
+	}
+
+	w.remaining -= int64(len(content))
+	w.reports.Payloads = append(w.reports.Payloads, Payload{Name: fileName(header), Data: content})
+
+	return nil
+}
+
+// walkFeedbackReport reads the parts of a failure report. The original message
+// is kept as it is, it is not walked for reports.
+func (w *messageWalker) walkFeedbackReport(body io.Reader, boundary string) error {
+	failure := FailurePayload{}
+
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read multipart: %w", err)
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		var target *[]byte
+		switch mediaType {
+		case "message/feedback-report":
+			target = &failure.Feedback
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers":
+			target = &failure.Original
+		default:
+			// The human readable description
+			continue
+		}
+
+		content, err := readLimited(decodeTransferEncoding(part.Header, part), w.remaining)
+		if err != nil {
+			return err
+		}
+
+		w.remaining -= int64(len(content))
+		*target = content
+	}
+
+	if failure.Feedback == nil {
+		return errors.New("feedback report has no message/feedback-report part")
+	}
+
+	w.reports.Failures = append(w.reports.Failures, failure)
+
+	return nil
+}
+
+// decodeTransferEncoding wraps the body in a decoder for its Content-Transfer-Encoding
+func decodeTransferEncoding(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+
+	return body
+}
+
+func isAttachment(header textproto.MIMEHeader) bool {
+	disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+
+	return err == nil && disposition == "attachment"
+}
+
+// fileName returns the attachment file name from the
+// Content-Disposition or Content-Type headers, if any
+func fileName(header textproto.MIMEHeader) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		return params["name"]
+	}
+
+	return ""
+}
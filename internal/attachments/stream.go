@@ -0,0 +1,156 @@
+package attachments
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+)
+
+// sniffSize is the number of leading bytes read to detect a format
+const sniffSize = 512
+
+// Stream is a single report of an attachment, decompressed while it is read
+type Stream struct {
+	Name string
+	// Format is FormatXML or FormatJSON
+	Format Format
+	io.Reader
+}
+
+// ExtractStreams calls fn with every report contained in an attachment, like
+// Extract, but decompresses gzip and zip containers while the reports are read
+// instead of in memory. Reading past the limits fails with ErrTooLarge. The
+// limits guard against decompression bombs, so plain reports are not limited:
+// they are read from r as they are, whatever their size.
+func ExtractStreams(name string, r io.ReaderAt, size int64, limits Limits, fn func(Stream) error) error {
+	head := make([]byte, min(size, sniffSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	remaining := limits.MaxDecompressedSize
+	content := io.NewSectionReader(r, 0, size)
+
+	switch format := Detect(head); format {
+	case FormatXML, FormatJSON:
+		return fn(Stream{Name: name, Format: format, Reader: content})
+
+	case FormatGzip:
+		reader, err := gzip.NewReader(content)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip: %w", err)
+		}
+		defer reader.Close()
+
+		stream, format, err := sniffReport(&limitedReader{reader: reader, remaining: &remaining})
+		if err != nil {
+			return err
+		}
+		if format == FormatUnknown {
+			return ErrUnknownFormat
+		}
+
+		// Prefer the original file name stored in the gzip header
+		if reader.Name != "" {
+			name = reader.Name
+		}
+
+		return fn(Stream{Name: trimExt(name, ".gz"), Format: format, Reader: stream})
+
+	case FormatZip:
+		return extractZipStreams(content, size, limits, &remaining, fn)
+	}
+
+	return ErrUnknownFormat
+}
+
+func extractZipStreams(r io.ReaderAt, size int64, limits Limits, remaining *int64, fn func(Stream) error) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+
+	if len(reader.File) > limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+
+	found := false
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		// The header can lie, but it lets us reject obvious bombs without reading them
+		if file.UncompressedSize64 > uint64(*remaining) {
+			return ErrTooLarge
+		}
+
+		err := func() error {
+			entry, err := file.Open()
+			if err != nil {
+				return fmt.Errorf("failed to open zip entry %s: %w", file.Name, err)
+			}
+			defer entry.Close()
+
+			stream, format, err := sniffReport(&limitedReader{reader: entry, remaining: remaining})
+			if err != nil {
+				return err
+			}
+
+			// Skip members that are not reports, like OS metadata files
+			if format == FormatUnknown {
+				return nil
+			}
+
+			found = true
+			return fn(Stream{Name: path.Base(file.Name), Format: format, Reader: stream})
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return ErrEmpty
+	}
+
+	return nil
+}
+
+// sniffReport returns the format of a decompressed stream, FormatXML, FormatJSON
+// or FormatUnknown if it is not a report, and a reader over the whole stream
+func sniffReport(r io.Reader) (io.Reader, Format, error) {
+	reader := bufio.NewReaderSize(r, sniffSize)
+
+	head, err := reader.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, FormatUnknown, err
+	}
+
+	if !isReport(head) {
+		return reader, FormatUnknown, nil
+	}
+
+	return reader, Detect(head), nil
+}
+
+// limitedReader fails with ErrTooLarge once more than remaining bytes are read,
+// the remaining bytes can be shared by the members of an attachment
+type limitedReader struct {
+	reader    io.Reader
+	remaining *int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+
+	*l.remaining -= int64(n)
+	if *l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+
+	return n, err
+}
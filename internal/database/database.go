@@ -1,14 +1,93 @@
 package database
 
 import (
+	"errors"
+	"time"
+
 	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+var (
+	// ErrDuplicateReport is returned by CreateReport, CreateFailureReport and CreateTLSReport
+	// when the exact same report already exists
+	ErrDuplicateReport = errors.New("report already exists")
+	// ErrConflictingReport is returned by CreateReport after storing a report, when a report
+	// of the same reporter with the same report ID but a different content already exists
+	ErrConflictingReport = errors.New("report conflicts with a stored report")
+	// ErrReportNotFound is returned when looking up a report, aggregate, failure or TLS, that does not exist
+	ErrReportNotFound = errors.New("report not found")
+	// ErrInvalidQuery is returned when a ReportQuery has an invalid sort column or cursor
+	ErrInvalidQuery = errors.New("invalid query")
 )
 
+// Columns reports can be sorted by
+const (
+	SortDateRangeBegin = "date_range_begin"
+	SortDateRangeEnd   = "date_range_end"
+	SortOrgName        = "org_name"
+	SortDomain         = "domain"
+)
+
+// ReportQuery filters, sorts and paginates reports
+type ReportQuery struct {
+	// Domain of the published policy, matched in its canonical form
+	Domain string
+	// OrgName of the reporter
+	OrgName string
+	// ReportID is only unique per reporter, it can match reports of several reporters
+	ReportID string
+	// From and To select reports whose date range overlaps them, zero values are open ends
+	From time.Time
+	To   time.Time
+	// HasFailures selects reports with (true) or without (false) records
+	// that failed DMARC, nil selects both
+	HasFailures *bool
+	// Conflicting selects only the reports that conflict with another stored report
+	Conflicting bool
+	// Sort is one of the Sort* columns, defaults to SortDateRangeBegin
+	Sort       string
+	Descending bool
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	Limit  int
+}
+
+// ReportPage is a page of reports, NextCursor is empty on the last page
+type ReportPage struct {
+	Reports    []*parsers.Report
+	NextCursor string
+}
+
+// ReportStream yields a report and then its records in chunks,
+// so large reports are stored without holding all of their records in memory
+type ReportStream interface {
+	// Report returns the report without its records. If its content hash is
+	// empty, it must be set once Next has returned io.EOF.
+	Report() *parsers.Report
+	// Next returns up to n records, and io.EOF after the last one
+	Next(n int) ([]parsers.Record, error)
+}
+
 type Storage interface {
 	Migrate() error
+	// Close waits for the write in progress, if any, and closes the database
+	Close() error
 	CreateReport(*parsers.Report) error
-	FindReportByReportID(string) (*parsers.Report, error)
+	CreateReportStream(ReportStream) error
+	FindReportByReportID(orgName string, reportID string) (*parsers.Report, error)
+	FindReportByHash(string) (*parsers.Report, error)
 	FindReports() ([]*parsers.Report, error)
-	CreateReportRecord(string, *parsers.Record) error
-	FindRecordsByReportID(string) ([]*parsers.Record, error)
+	QueryReports(ReportQuery) (*ReportPage, error)
+	CreateReportRecord(uint, *parsers.Record) error
+	FindRecordsByReportID(orgName string, reportID string) ([]*parsers.Record, error)
+	FindReporterErrors(since time.Time) ([]*types.ReporterErrors, error)
+	CreateFailureReport(*parsers.FailureReport) error
+	FindFailureReportByHash(string) (*parsers.FailureReport, error)
+	// FindFailureReports returns the failure reports about a domain, or all of them if it is empty
+	FindFailureReports(domain string) ([]*parsers.FailureReport, error)
+	CreateTLSReport(*parsers.TLSReport) error
+	FindTLSReportByHash(string) (*parsers.TLSReport, error)
+	// FindTLSReports returns the TLS reports with a policy for a domain, or all of them if it is empty
+	FindTLSReports(domain string) ([]*parsers.TLSReport, error)
 }
@@ -31,5 +31,8 @@ func (s *SqliteStorage) CreateAddress(address *types.Address) error {
 		Hostname: strings.Join(hostname, ","),
 	}
 
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
 	return s.db.Create(addr).Error
 }
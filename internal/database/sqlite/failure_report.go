@@ -0,0 +1,166 @@
+package database_sqlite
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"gorm.io/gorm"
+)
+
+// FailureReportModel holds a failure report, a sample of a single message
+// that failed authentication. Resends share the content hash.
+type FailureReportModel struct {
+	ID                      uint   `gorm:"primaryKey"`
+	CreatedAt               int64  `gorm:"autoCreateTime"`
+	ContentHash             string `gorm:"uniqueIndex"`
+	FeedbackType            string
+	UserAgent               string
+	Version                 string
+	AuthFailure             string
+	ReportedDomain          string `gorm:"index"`
+	OriginalReportedDomain  string
+	SourceIP                string
+	ArrivalDate             time.Time
+	ReportingMTA            string
+	OriginalEnvelopeID      string
+	OriginalMailFrom        string
+	OriginalRcptTo          string
+	DeliveryResult          string
+	AuthenticationResults   string
+	IdentityAlignment       string
+	DKIMDomain              string
+	DKIMIdentity            string
+	DKIMSelector            string
+	DKIMCanonicalizedHeader string
+	DKIMCanonicalizedBody   string
+	SPFDNS                  string
+	HeaderFrom              string
+	Subject                 string
+	MessageID               string
+	OriginalHeaders         string
+	BodyExcerpt             string
+}
+
+// CreateFailureReport stores a failure report.
+// It returns database.ErrDuplicateReport for an exact resend of a stored report.
+func (s *SqliteStorage) CreateFailureReport(report *parsers.FailureReport) error {
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
+	if err := s.db.Create(FailureReportToModel(report)).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return database.ErrDuplicateReport
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// FindFailureReportByHash returns the failure report with the given content hash
+func (s *SqliteStorage) FindFailureReportByHash(hash string) (*parsers.FailureReport, error) {
+	model := &FailureReportModel{}
+
+	if err := s.db.Where("content_hash = ?", hash).First(model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, database.ErrReportNotFound
+		}
+		return nil, err
+	}
+
+	return ModelToFailureReport(model), nil
+}
+
+// FindFailureReports returns the failure reports about a domain, or all of them
+// if it is empty, in the order the messages arrived
+func (s *SqliteStorage) FindFailureReports(domain string) ([]*parsers.FailureReport, error) {
+	models := []*FailureReportModel{}
+
+	tx := s.db.Order("arrival_date, id")
+	if domain != "" {
+		tx = tx.Where("reported_domain = ?", parsers.NormalizeDomain(domain))
+	}
+
+	if err := tx.Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	reports := make([]*parsers.FailureReport, len(models))
+	for idx, model := range models {
+		// Convert the FailureReportModel to a parsers.FailureReport
+		reports[idx] = ModelToFailureReport(model)
+	}
+
+	return reports, nil
+}
+
+// Converts a parsers.FailureReport to a FailureReportModel
+func FailureReportToModel(r *parsers.FailureReport) *FailureReportModel {
+	return &FailureReportModel{
+		ContentHash:             r.ContentHash,
+		FeedbackType:            r.FeedbackType,
+		UserAgent:               r.UserAgent,
+		Version:                 r.Version,
+		AuthFailure:             r.AuthFailure,
+		ReportedDomain:          r.ReportedDomain,
+		OriginalReportedDomain:  r.OriginalReportedDomain,
+		SourceIP:                r.SourceIP,
+		ArrivalDate:             time.Unix(r.ArrivalDate, 0).UTC(),
+		ReportingMTA:            r.ReportingMTA,
+		OriginalEnvelopeID:      r.OriginalEnvelopeID,
+		OriginalMailFrom:        r.OriginalMailFrom,
+		OriginalRcptTo:          r.OriginalRcptTo,
+		DeliveryResult:          r.DeliveryResult,
+		AuthenticationResults:   r.AuthenticationResults,
+		IdentityAlignment:       r.IdentityAlignment,
+		DKIMDomain:              r.DKIM.Domain,
+		DKIMIdentity:            r.DKIM.Identity,
+		DKIMSelector:            r.DKIM.Selector,
+		DKIMCanonicalizedHeader: r.DKIM.CanonicalizedHeader,
+		DKIMCanonicalizedBody:   r.DKIM.CanonicalizedBody,
+		SPFDNS:                  r.SPFDNS,
+		HeaderFrom:              r.HeaderFrom,
+		Subject:                 r.Subject,
+		MessageID:               r.MessageID,
+		OriginalHeaders:         r.OriginalHeaders,
+		BodyExcerpt:             r.BodyExcerpt,
+	}
+}
+
+// Converts a FailureReportModel to a parsers.FailureReport
+func ModelToFailureReport(r *FailureReportModel) *parsers.FailureReport {
+	return &parsers.FailureReport{
+		ContentHash:            r.ContentHash,
+		FeedbackType:           r.FeedbackType,
+		UserAgent:              r.UserAgent,
+		Version:                r.Version,
+		AuthFailure:            r.AuthFailure,
+		ReportedDomain:         r.ReportedDomain,
+		OriginalReportedDomain: r.OriginalReportedDomain,
+		SourceIP:               r.SourceIP,
+		ArrivalDate:            r.ArrivalDate.Unix(),
+		ReportingMTA:           r.ReportingMTA,
+		OriginalEnvelopeID:     r.OriginalEnvelopeID,
+		OriginalMailFrom:       r.OriginalMailFrom,
+		OriginalRcptTo:         r.OriginalRcptTo,
+		DeliveryResult:         r.DeliveryResult,
+		AuthenticationResults:  r.AuthenticationResults,
+		IdentityAlignment:      r.IdentityAlignment,
+		DKIM: parsers.FailureDKIM{
+			Domain:              r.DKIMDomain,
+			Identity:            r.DKIMIdentity,
+			Selector:            r.DKIMSelector,
+			CanonicalizedHeader: r.DKIMCanonicalizedHeader,
+			CanonicalizedBody:   r.DKIMCanonicalizedBody,
+		},
+		SPFDNS:          r.SPFDNS,
+		HeaderFrom:      r.HeaderFrom,
+		Subject:         r.Subject,
+		MessageID:       r.MessageID,
+		OriginalHeaders: r.OriginalHeaders,
+		BodyExcerpt:     r.BodyExcerpt,
+	}
+}
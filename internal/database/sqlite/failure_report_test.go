@@ -0,0 +1,57 @@
+package database_sqlite
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+func TestCreateFailureReport(t *testing.T) {
+	store := newTestStorage(t)
+
+	report := &parsers.FailureReport{
+		FeedbackType:           parsers.FeedbackTypeAuthFailure,
+		Version:                "1",
+		AuthFailure:            "dkim",
+		ReportedDomain:         "example.com",
+		OriginalReportedDomain: "Example.COM.",
+		SourceIP:               "192.0.2.1",
+		ArrivalDate:            1695636118,
+		DKIM:                   parsers.FailureDKIM{Domain: "example.com", Selector: "s1024"},
+		HeaderFrom:             "example.com",
+		OriginalHeaders:        "From: Sales <sales@example.com>",
+		ContentHash:            "hash",
+	}
+
+	if err := store.CreateFailureReport(report); err != nil {
+		t.Fatalf("failed to store failure report: %s", err)
+	}
+
+	if err := store.CreateFailureReport(report); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error, got %v", err)
+	}
+
+	stored, err := store.FindFailureReportByHash("hash")
+	if err != nil {
+		t.Fatalf("failed to find failure report: %s", err)
+	}
+	if *stored != *report {
+		t.Errorf("stored failure report does not match\nwant: %+v\ngot:  %+v", report, stored)
+	}
+
+	if _, err := store.FindFailureReportByHash("missing"); !errors.Is(err, database.ErrReportNotFound) {
+		t.Errorf("expected a report not found error, got %v", err)
+	}
+
+	for domain, expected := range map[string]int{"": 1, "EXAMPLE.com.": 1, "example.net": 0} {
+		reports, err := store.FindFailureReports(domain)
+		if err != nil {
+			t.Fatalf("failed to find failure reports: %s", err)
+		}
+		if len(reports) != expected {
+			t.Errorf("expected %d failure reports about %q, got %d", expected, domain, len(reports))
+		}
+	}
+}
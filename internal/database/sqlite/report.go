@@ -2,96 +2,389 @@ package database_sqlite
 
 import (
 	"errors"
+	"io"
 	"time"
 
-	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
 	"gorm.io/gorm"
 )
 
+// ReportModel is identified by the reporter org name and the report ID,
+// as report IDs are only unique per reporter. Resends of the same report
+// share the content hash, while reports with the same identity but different
+// content are all kept and flagged as conflicting for review.
+//
+// The reporter email is left out of the identity. It is a contact address,
+// reporters change it without changing how they number reports, and a report
+// resent after such a change would be stored twice instead of being skipped.
+// Two reporters sharing an org name with different emails show up as
+// conflicting reports instead, which are kept and can be told apart by email.
 type ReportModel struct {
-	ReportID                               string `gorm:"primaryKey"`
-	CreatedAt                              int64  `gorm:"autoCreateTime"`
-	Version                                string
-	ReportMetadataOrgName                  string
-	ReportMetadataEmail                    string
-	ReportMetadataExtraContactInfo         string
-	ReportDateRangeBegin                   time.Time
-	ReportDateRangeEnd                     time.Time
-	PolicyPublishedDomain                  string
-	PolicyPublishedAlignmentModeDKIM       string
-	PolicyPublishedAlignmentModeSPF        string
-	PolicyPublishedPolicy                  string
-	PolicyPublishedSubdomainPolicy         string
-	PolicyPublishedPercentage              int
-	PolicyPublishedFailureReportingOptions string
+	ID          uint   `gorm:"primaryKey"`
+	CreatedAt   int64  `gorm:"autoCreateTime"`
+	ReportID    string `gorm:"uniqueIndex:idx_report_identity,priority:2"`
+	ContentHash string `gorm:"uniqueIndex:idx_report_identity,priority:3"`
+	Conflict    bool
+	Version     string
+	// Reports stored before formats were detected are all in the RFC 7489 format
+	Format                                    string `gorm:"default:rfc7489"`
+	ReportMetadataOrgName                     string `gorm:"uniqueIndex:idx_report_identity,priority:1"`
+	ReportMetadataEmail                       string
+	ReportMetadataExtraContactInfo            string
+	ReportMetadataGenerator                   string
+	ReportDateRangeBegin                      time.Time
+	ReportDateRangeEnd                        time.Time
+	ReportMetadataErrors                      []ReportErrorModel       `gorm:"foreignKey:ReportModelID;constraint:OnDelete:CASCADE"`
+	SchemaIssues                              []ReportSchemaIssueModel `gorm:"foreignKey:ReportModelID;constraint:OnDelete:CASCADE"`
+	Extensions                                []ReportExtensionModel   `gorm:"foreignKey:ReportModelID;constraint:OnDelete:CASCADE"`
+	PolicyPublishedDomain                     string
+	PolicyPublishedOriginalDomain             string
+	PolicyPublishedAlignmentModeDKIM          string
+	PolicyPublishedAlignmentModeSPF           string
+	PolicyPublishedPolicy                     string
+	PolicyPublishedSubdomainPolicy            string
+	PolicyPublishedPercentage                 *int
+	PolicyPublishedFailureReportingOptions    string
+	PolicyPublishedNonexistentSubdomainPolicy string
+	PolicyPublishedTesting                    string
+	PolicyPublishedDiscoveryMethod            string
 }
 
+// CreateReport stores the report and all its records in a single transaction,
+// so a report is either stored whole or not at all.
+// It returns database.ErrDuplicateReport for an exact resend of a stored report,
+// and database.ErrConflictingReport, after storing it, for a report that has
+// the same identity as a stored report but a different content.
 func (s *SqliteStorage) CreateReport(report *parsers.Report) error {
-	r := ReportToModel(report)
+	return s.CreateReportStream(&reportRecords{report: report})
+}
+
+// pendingContentHash stands in for the content hash of a streamed report until
+// all of it is read. It is never committed, and cannot clash with a real hash.
+const pendingContentHash = "pending"
 
-	err := s.db.Create(r).Error
+// maxBufferedRecords is the number of records of a stream read before the write
+// lock is taken, so most reports are decompressed and parsed while other reports
+// are written. The records of larger reports are read on while they are written.
+const maxBufferedRecords = 20 * recordsBatchSize
+
+// CreateReportStream stores a report, inserting its records in batches as they
+// are read from the stream, in a single transaction. It returns the same errors
+// as CreateReport, and any error of the stream after rolling back.
+func (s *SqliteStorage) CreateReportStream(stream database.ReportStream) error {
+	stream, err := bufferRecords(stream, maxBufferedRecords)
 	if err != nil {
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
-			log.Infof("Report with ID %s already exists, skipping", report.ReportMetadata.ReportID)
-			return nil
+		return err
+	}
+
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
+	report := stream.Report()
+	r := ReportToModel(report)
+
+	// Reports parsed in memory have their content hash upfront, so resends are
+	// skipped without reading their records. Streamed reports are only hashed
+	// once read, so they are stored first and rolled back if they are resends.
+	hashed := r.ContentHash != ""
+	if !hashed {
+		r.ContentHash = pendingContentHash
+	}
+
+	conflict, backfilled := false, false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// The report row is only visible once every record is committed,
+		// so finding it means the whole report was already stored
+		existing := []*ReportModel{}
+		err := tx.Select("id", "content_hash").
+			Where("report_metadata_org_name = ? AND report_id = ?", r.ReportMetadataOrgName, r.ReportID).
+			Find(&existing).Error
+		if err != nil {
+			return err
+		}
+
+		if hashed {
+			duplicate, legacy := matchStoredReport(existing, r.ContentHash)
+			if duplicate {
+				return database.ErrDuplicateReport
+			}
+			if legacy != nil {
+				backfilled = true
+				return tx.Model(legacy).Update("content_hash", r.ContentHash).Error
+			}
+		}
+
+		if err := tx.SavePoint("report").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(r).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return database.ErrDuplicateReport
+			}
+
+			return err
+		}
+
+		// Elements after the records are only known at the end of a stream
+		extensions := len(report.Extensions)
+		if err := createRecords(tx, r.ID, stream); err != nil {
+			return err
+		}
+
+		if !hashed {
+			r.ContentHash = report.ContentHash
+
+			duplicate, legacy := matchStoredReport(existing, r.ContentHash)
+			if duplicate {
+				return database.ErrDuplicateReport
+			}
+			if legacy != nil {
+				backfilled = true
+				if err := tx.RollbackTo("report").Error; err != nil {
+					return err
+				}
+				return tx.Model(legacy).Update("content_hash", r.ContentHash).Error
+			}
+
+			if err := tx.Model(r).Update("content_hash", r.ContentHash).Error; err != nil {
+				return err
+			}
 		}
 
+		for _, extension := range report.Extensions[extensions:] {
+			model := &ReportExtensionModel{ReportModelID: r.ID, Level: extensionLevelFeedback, Name: extension.Name, XML: extension.XML}
+			if err := tx.Create(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(existing) > 0 {
+			conflict = true
+
+			// Flag the stored reports too, so all versions show up for review
+			err := tx.Model(&ReportModel{}).
+				Where("report_metadata_org_name = ? AND report_id = ?", r.ReportMetadataOrgName, r.ReportID).
+				Update("conflict", true).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	for _, record := range report.Records {
-		if err := s.CreateReportRecord(report.ReportMetadata.ReportID, &record); err != nil {
+	if backfilled {
+		return database.ErrDuplicateReport
+	}
+
+	if conflict {
+		return database.ErrConflictingReport
+	}
+
+	return nil
+}
+
+// matchStoredReport compares a content hash with the stored reports of the same identity.
+// Reports stored before content hashes were recorded have none, a single one of
+// them is assumed to be the same report, and returned so its hash is recorded.
+func matchStoredReport(existing []*ReportModel, hash string) (bool, *ReportModel) {
+	for _, model := range existing {
+		if model.ContentHash == hash {
+			return true, nil
+		}
+	}
+
+	if len(existing) == 1 && existing[0].ContentHash == "" {
+		return false, existing[0]
+	}
+
+	return false, nil
+}
+
+// createRecords inserts the records of a stream in batches
+func createRecords(tx *gorm.DB, reportModelID uint, stream database.ReportStream) error {
+	for {
+		batch, err := stream.Next(recordsBatchSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		records := make([]*ReportRecordModel, len(batch))
+		for idx := range batch {
+			records[idx] = ReportRecordToModel(reportModelID, &batch[idx])
+		}
+
+		if err := tx.CreateInBatches(records, recordsBatchSize).Error; err != nil {
 			return err
 		}
 	}
+}
 
-	return nil
+// bufferedStream holds the first records of a stream, read before the write lock is taken
+type bufferedStream struct {
+	database.ReportStream
+	batches [][]parsers.Record
+	// done is set once the stream returned io.EOF
+	done bool
+}
+
+// bufferRecords reads up to limit records of a stream in batches. Once the stream
+// is read to the end, its report has its content hash, and resends are skipped
+// without inserting their records.
+func bufferRecords(stream database.ReportStream, limit int) (*bufferedStream, error) {
+	buffered := &bufferedStream{ReportStream: stream}
+
+	for records := 0; records < limit; {
+		batch, err := stream.Next(recordsBatchSize)
+		if err == io.EOF {
+			buffered.done = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		buffered.batches = append(buffered.batches, batch)
+		records += len(batch)
+	}
+
+	return buffered, nil
+}
+
+func (b *bufferedStream) Next(n int) ([]parsers.Record, error) {
+	if len(b.batches) == 0 {
+		if b.done {
+			return nil, io.EOF
+		}
+		return b.ReportStream.Next(n)
+	}
+
+	batch := b.batches[0]
+	if len(batch) > n {
+		b.batches[0] = batch[n:]
+		return batch[:n], nil
+	}
+
+	b.batches = b.batches[1:]
+	return batch, nil
+}
+
+// reportRecords streams the records of a report parsed in memory
+type reportRecords struct {
+	report *parsers.Report
+	offset int
+}
+
+func (r *reportRecords) Report() *parsers.Report {
+	return r.report
+}
+
+func (r *reportRecords) Next(n int) ([]parsers.Record, error) {
+	if r.offset >= len(r.report.Records) {
+		return nil, io.EOF
+	}
+
+	end := min(r.offset+n, len(r.report.Records))
+	records := r.report.Records[r.offset:end]
+	r.offset = end
+
+	return records, nil
+}
+
+// FindReportByReportID returns the report of a reporter with the given report ID.
+// If conflicting versions of the report exist, the first one stored is returned.
+func (s *SqliteStorage) FindReportByReportID(orgName string, reportID string) (*parsers.Report, error) {
+	return s.findReport(s.db.Where("report_metadata_org_name = ? AND report_id = ?", orgName, reportID).Order("id"))
+}
+
+// FindReportByHash returns the report with the given content hash
+func (s *SqliteStorage) FindReportByHash(hash string) (*parsers.Report, error) {
+	return s.findReport(s.db.Where("content_hash = ?", hash))
 }
 
-func (s *SqliteStorage) FindReportByReportID(reportID string) (*parsers.Report, error) {
+// findReport returns the first report matching the query, with its records
+func (s *SqliteStorage) findReport(query *gorm.DB) (*parsers.Report, error) {
 	report := &ReportModel{}
 
-	if err := s.db.Where("report_id = ?", reportID).First(report).Error; err != nil {
+	if err := query.Preload("ReportMetadataErrors").Preload("SchemaIssues").Preload("Extensions").First(report).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, database.ErrReportNotFound
+		}
 		return nil, err
 	}
 
-	records, err := s.FindRecordsByReportID(reportID)
+	records, err := s.findRecordsByReportModelIDs([]uint{report.ID})
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert the ReportModel to a parsers.Report
-	return ModelToReport(report, records), nil
+	return ModelToReport(report, records[report.ID]), nil
 }
 
+// FindReports returns all the reports with their records
 func (s *SqliteStorage) FindReports() ([]*parsers.Report, error) {
-	var reports []*parsers.Report
+	models := []*ReportModel{}
+
+	if err := s.db.Preload("ReportMetadataErrors").Preload("SchemaIssues").Preload("Extensions").Order("report_date_range_begin, id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(models))
+	for idx, model := range models {
+		ids[idx] = model.ID
+	}
 
-	if err := s.db.Find(&reports).Error; err != nil {
+	// Load the records of all reports at once, instead of a query per report
+	records, err := s.findRecordsByReportModelIDs(ids)
+	if err != nil {
 		return nil, err
 	}
 
+	reports := make([]*parsers.Report, len(models))
+	for idx, model := range models {
+		// Convert the ReportModel to a parsers.Report
+		reports[idx] = ModelToReport(model, records[model.ID])
+	}
+
 	return reports, nil
 }
 
 // Converts a parsers.Report to a ReportModel
 func ReportToModel(r *parsers.Report) *ReportModel {
 	return &ReportModel{
-		ReportID:                               r.ReportMetadata.ReportID,
-		Version:                                r.Version,
-		ReportMetadataOrgName:                  r.ReportMetadata.OrgName,
-		ReportMetadataEmail:                    r.ReportMetadata.Email,
-		ReportMetadataExtraContactInfo:         r.ReportMetadata.ExtraContactInfo,
-		ReportDateRangeBegin:                   time.Unix(r.ReportMetadata.DateRange.Begin, 0).UTC(),
-		ReportDateRangeEnd:                     time.Unix(r.ReportMetadata.DateRange.End, 0).UTC(),
-		PolicyPublishedDomain:                  r.PolicyPublished.Domain,
-		PolicyPublishedAlignmentModeDKIM:       r.PolicyPublished.AlignmentModeDKIM,
-		PolicyPublishedAlignmentModeSPF:        r.PolicyPublished.AlignmentModeSPF,
-		PolicyPublishedPolicy:                  r.PolicyPublished.Policy,
-		PolicyPublishedSubdomainPolicy:         r.PolicyPublished.SubdomainPolicy,
-		PolicyPublishedPercentage:              r.PolicyPublished.Percentage,
-		PolicyPublishedFailureReportingOptions: r.PolicyPublished.FailureReportingOptions,
+		ReportID:                                  r.ReportMetadata.ReportID,
+		ContentHash:                               r.ContentHash,
+		Version:                                   r.Version,
+		Format:                                    r.Format,
+		ReportMetadataOrgName:                     r.ReportMetadata.OrgName,
+		ReportMetadataEmail:                       r.ReportMetadata.Email,
+		ReportMetadataExtraContactInfo:            r.ReportMetadata.ExtraContactInfo,
+		ReportMetadataGenerator:                   r.ReportMetadata.Generator,
+		ReportDateRangeBegin:                      time.Unix(r.ReportMetadata.DateRange.Begin, 0).UTC(),
+		ReportDateRangeEnd:                        time.Unix(r.ReportMetadata.DateRange.End, 0).UTC(),
+		ReportMetadataErrors:                      ReportErrorsToModel(r.ReportMetadata.Errors),
+		SchemaIssues:                              SchemaIssuesToModel(r.SchemaIssues),
+		Extensions:                                ReportExtensionsToModel(r),
+		PolicyPublishedDomain:                     r.PolicyPublished.Domain,
+		PolicyPublishedOriginalDomain:             r.PolicyPublished.OriginalDomain,
+		PolicyPublishedAlignmentModeDKIM:          r.PolicyPublished.AlignmentModeDKIM,
+		PolicyPublishedAlignmentModeSPF:           r.PolicyPublished.AlignmentModeSPF,
+		PolicyPublishedPolicy:                     r.PolicyPublished.Policy,
+		PolicyPublishedSubdomainPolicy:            r.PolicyPublished.SubdomainPolicy,
+		PolicyPublishedPercentage:                 r.PolicyPublished.Percentage,
+		PolicyPublishedFailureReportingOptions:    r.PolicyPublished.FailureReportingOptions,
+		PolicyPublishedNonexistentSubdomainPolicy: r.PolicyPublished.NonexistentSubdomainPolicy,
+		PolicyPublishedTesting:                    r.PolicyPublished.Testing,
+		PolicyPublishedDiscoveryMethod:            r.PolicyPublished.DiscoveryMethod,
 	}
 }
 
@@ -103,26 +396,38 @@ func ModelToReport(r *ReportModel, recs []*parsers.Record) *parsers.Report {
 	}
 
 	return &parsers.Report{
-		Version: r.Version,
-		Records: records,
+		Version:      r.Version,
+		Format:       r.Format,
+		Records:      records,
+		ContentHash:  r.ContentHash,
+		Conflict:     r.Conflict,
+		SchemaIssues: ModelToSchemaIssues(r.SchemaIssues),
+		Extensions:   ModelToReportExtensions(r.Extensions, extensionLevelFeedback),
 		ReportMetadata: parsers.ReportMetadata{
 			OrgName:          r.ReportMetadataOrgName,
 			Email:            r.ReportMetadataEmail,
 			ExtraContactInfo: r.ReportMetadataExtraContactInfo,
+			Generator:        r.ReportMetadataGenerator,
 			ReportID:         r.ReportID,
 			DateRange: parsers.DateRange{
 				Begin: r.ReportDateRangeBegin.Unix(),
 				End:   r.ReportDateRangeEnd.Unix(),
 			},
+			Errors:     ModelToReportErrors(r.ReportMetadataErrors),
+			Extensions: ModelToReportExtensions(r.Extensions, extensionLevelReportMetadata),
 		},
 		PolicyPublished: parsers.PolicyPublished{
-			Domain:                  r.PolicyPublishedDomain,
-			AlignmentModeDKIM:       r.PolicyPublishedAlignmentModeDKIM,
-			AlignmentModeSPF:        r.PolicyPublishedAlignmentModeSPF,
-			Policy:                  r.PolicyPublishedPolicy,
-			SubdomainPolicy:         r.PolicyPublishedSubdomainPolicy,
-			Percentage:              r.PolicyPublishedPercentage,
-			FailureReportingOptions: r.PolicyPublishedFailureReportingOptions,
+			Domain:                     r.PolicyPublishedDomain,
+			OriginalDomain:             r.PolicyPublishedOriginalDomain,
+			AlignmentModeDKIM:          r.PolicyPublishedAlignmentModeDKIM,
+			AlignmentModeSPF:           r.PolicyPublishedAlignmentModeSPF,
+			Policy:                     r.PolicyPublishedPolicy,
+			SubdomainPolicy:            r.PolicyPublishedSubdomainPolicy,
+			Percentage:                 r.PolicyPublishedPercentage,
+			FailureReportingOptions:    r.PolicyPublishedFailureReportingOptions,
+			NonexistentSubdomainPolicy: r.PolicyPublishedNonexistentSubdomainPolicy,
+			Testing:                    r.PolicyPublishedTesting,
+			DiscoveryMethod:            r.PolicyPublishedDiscoveryMethod,
 		},
 	}
 }
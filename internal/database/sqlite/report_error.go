@@ -0,0 +1,82 @@
+package database_sqlite
+
+import (
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+// ReportErrorModel holds a single error the reporter included
+// in the report metadata, usually about processing our policy
+type ReportErrorModel struct {
+	ID            uint  `gorm:"primaryKey"`
+	CreatedAt     int64 `gorm:"autoCreateTime"`
+	ReportModelID uint  `gorm:"index"`
+	Message       string
+}
+
+// FindReporterErrors returns the errors of the reports whose date range ends
+// after the given time, grouped by the reporter organization name
+func (s *SqliteStorage) FindReporterErrors(since time.Time) ([]*types.ReporterErrors, error) {
+	rows := []struct {
+		ReportMetadataOrgName string
+		ReportMetadataEmail   string
+		ReportID              string
+		ReportDateRangeBegin  time.Time
+		ReportDateRangeEnd    time.Time
+		Message               string
+	}{}
+
+	err := s.db.Model(&ReportErrorModel{}).
+		Select("report_models.report_metadata_org_name, report_models.report_metadata_email, "+
+			"report_models.report_id, report_models.report_date_range_begin, "+
+			"report_models.report_date_range_end, report_error_models.message").
+		Joins("JOIN report_models ON report_models.id = report_error_models.report_model_id").
+		Where("report_models.report_date_range_end >= ?", since.UTC()).
+		Order("report_models.report_metadata_org_name, report_models.report_date_range_end DESC, report_error_models.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are ordered by org name, so each group is contiguous
+	reporters := []*types.ReporterErrors{}
+	for _, row := range rows {
+		if len(reporters) == 0 || reporters[len(reporters)-1].OrgName != row.ReportMetadataOrgName {
+			reporters = append(reporters, &types.ReporterErrors{OrgName: row.ReportMetadataOrgName})
+		}
+
+		reporter := reporters[len(reporters)-1]
+		reporter.Errors = append(reporter.Errors, types.ReporterError{
+			ReportID:       row.ReportID,
+			Email:          row.ReportMetadataEmail,
+			DateRangeBegin: row.ReportDateRangeBegin,
+			DateRangeEnd:   row.ReportDateRangeEnd,
+			Message:        row.Message,
+		})
+	}
+
+	return reporters, nil
+}
+
+// Converts a slice of report metadata errors to a slice of ReportErrorModel
+func ReportErrorsToModel(errors []string) []ReportErrorModel {
+	models := make([]ReportErrorModel, len(errors))
+	for idx, message := range errors {
+		models[idx] = ReportErrorModel{
+			Message: message,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportErrorModel to a slice of report metadata errors
+func ModelToReportErrors(models []ReportErrorModel) []string {
+	errors := make([]string, len(models))
+	for idx, model := range models {
+		errors[idx] = model.Message
+	}
+
+	return errors
+}
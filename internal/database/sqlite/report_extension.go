@@ -0,0 +1,81 @@
+package database_sqlite
+
+import (
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// Levels of a report an extension can be found at
+const (
+	extensionLevelFeedback       = "feedback"
+	extensionLevelReportMetadata = "report_metadata"
+)
+
+// ReportExtensionModel holds an unknown element of a report,
+// found in the feedback or the report metadata element
+type ReportExtensionModel struct {
+	ID            uint  `gorm:"primaryKey"`
+	CreatedAt     int64 `gorm:"autoCreateTime"`
+	ReportModelID uint  `gorm:"index"`
+	Level         string
+	Name          string
+	XML           string
+}
+
+// Converts the extensions of a parsers.Report to a slice of ReportExtensionModel
+func ReportExtensionsToModel(r *parsers.Report) []ReportExtensionModel {
+	models := make([]ReportExtensionModel, 0, len(r.Extensions)+len(r.ReportMetadata.Extensions))
+	for _, extension := range r.Extensions {
+		models = append(models, ReportExtensionModel{Level: extensionLevelFeedback, Name: extension.Name, XML: extension.XML})
+	}
+	for _, extension := range r.ReportMetadata.Extensions {
+		models = append(models, ReportExtensionModel{Level: extensionLevelReportMetadata, Name: extension.Name, XML: extension.XML})
+	}
+
+	return models
+}
+
+// Converts the ReportExtensionModel of a level to a slice of parsers.Extension
+func ModelToReportExtensions(models []ReportExtensionModel, level string) []parsers.Extension {
+	var extensions []parsers.Extension
+	for _, model := range models {
+		if model.Level == level {
+			extensions = append(extensions, parsers.Extension{Name: model.Name, XML: model.XML})
+		}
+	}
+
+	return extensions
+}
+
+// ReportRecordExtensionModel holds an unknown element of a record
+type ReportRecordExtensionModel struct {
+	ID             uint  `gorm:"primaryKey"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	ReportRecordID uint  `gorm:"index"`
+	Name           string
+	XML            string
+}
+
+// Converts a slice of parsers.Extension to a slice of ReportRecordExtensionModel
+func RecordExtensionsToModel(extensions []parsers.Extension) []ReportRecordExtensionModel {
+	models := make([]ReportRecordExtensionModel, len(extensions))
+	for idx, extension := range extensions {
+		models[idx] = ReportRecordExtensionModel{Name: extension.Name, XML: extension.XML}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportRecordExtensionModel to a slice of parsers.Extension
+func ModelToRecordExtensions(models []ReportRecordExtensionModel) []parsers.Extension {
+	// Most records have no extensions, keep the field omitted from the JSON
+	if len(models) == 0 {
+		return nil
+	}
+
+	extensions := make([]parsers.Extension, len(models))
+	for idx, model := range models {
+		extensions[idx] = parsers.Extension{Name: model.Name, XML: model.XML}
+	}
+
+	return extensions
+}
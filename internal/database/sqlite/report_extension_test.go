@@ -0,0 +1,56 @@
+package database_sqlite
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+func TestReportExtensionsRoundTrip(t *testing.T) {
+	store := newTestStorage(t)
+	loadTestReports(t, store, "extensions.xml")
+
+	stored, err := store.FindReportByReportID("mail.example.org", "extensionsreportid")
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+
+	// The fragments keep their prefixes and carry the declarations of the feedback element
+	levels := map[string]struct {
+		extensions []parsers.Extension
+		expected   []parsers.Extension
+	}{
+		"feedback": {stored.Extensions, []parsers.Extension{{
+			Name: "extension",
+			XML:  "<extension xmlns:vendor=\"urn:example:vendor\">\n    <vendor:report_stats>\n      <vendor:messages>7</vendor:messages>\n    </vendor:report_stats>\n  </extension>",
+		}}},
+		"report metadata": {stored.ReportMetadata.Extensions, []parsers.Extension{{
+			Name: "contact_form",
+			XML:  `<vendor:contact_form xmlns:vendor="urn:example:vendor" href="https://mail.example.org/dmarc">DMARC support</vendor:contact_form>`,
+		}}},
+		"record": {stored.Records[0].Extensions, []parsers.Extension{{
+			Name: "envelope_info",
+			XML:  "<vendor:envelope_info xmlns:vendor=\"urn:example:vendor\">\n      <vendor:rcpt_domain>example.net</vendor:rcpt_domain>\n    </vendor:envelope_info>",
+		}}},
+	}
+
+	for name, level := range levels {
+		if !reflect.DeepEqual(level.extensions, level.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, level.expected, level.extensions)
+			continue
+		}
+
+		// A stored fragment resolves to the same namespace on its own
+		var element struct {
+			XMLName xml.Name
+		}
+		if err := xml.Unmarshal([]byte(level.extensions[0].XML), &element); err != nil {
+			t.Errorf("%s: failed to parse the stored fragment: %s", name, err)
+		}
+		if name != "feedback" && element.XMLName.Space != "urn:example:vendor" {
+			t.Errorf("%s: expected the vendor namespace, got %q", name, element.XMLName.Space)
+		}
+	}
+}
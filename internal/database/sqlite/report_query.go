@@ -0,0 +1,181 @@
+package database_sqlite
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+const (
+	defaultQueryLimit = 50
+	maxQueryLimit     = 500
+)
+
+// Maps the sortable columns to the ReportModel columns
+var sortColumns = map[string]string{
+	database.SortDateRangeBegin: "report_date_range_begin",
+	database.SortDateRangeEnd:   "report_date_range_end",
+	database.SortOrgName:        "report_metadata_org_name",
+	database.SortDomain:         "policy_published_domain",
+}
+
+// A record fails DMARC when neither DKIM nor SPF passed with alignment
+const failedRecordsQuery = "SELECT 1 FROM report_record_models " +
+	"WHERE report_record_models.report_model_id = report_models.id " +
+	"AND report_record_models.policy_evaluated_dkim != 'pass' " +
+	"AND report_record_models.policy_evaluated_spf != 'pass'"
+
+var errInvalidCursor = fmt.Errorf("%w: malformed cursor", database.ErrInvalidQuery)
+
+// reportCursor points after the last report of a page, using the sort
+// column value and the row ID as a tie breaker
+type reportCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// QueryReports returns a page of reports, without their records
+func (s *SqliteStorage) QueryReports(query database.ReportQuery) (*database.ReportPage, error) {
+	if query.Sort == "" {
+		query.Sort = database.SortDateRangeBegin
+	}
+
+	column, ok := sortColumns[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort column %s", database.ErrInvalidQuery, query.Sort)
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultQueryLimit
+	}
+	if query.Limit > maxQueryLimit {
+		query.Limit = maxQueryLimit
+	}
+
+	tx := s.db.Model(&ReportModel{}).Preload("ReportMetadataErrors").Preload("SchemaIssues").Preload("Extensions")
+
+	if query.Domain != "" {
+		tx = tx.Where("policy_published_domain = ?", parsers.NormalizeDomain(query.Domain))
+	}
+
+	if query.OrgName != "" {
+		tx = tx.Where("report_metadata_org_name = ?", query.OrgName)
+	}
+
+	if query.ReportID != "" {
+		tx = tx.Where("report_id = ?", query.ReportID)
+	}
+
+	if !query.From.IsZero() {
+		tx = tx.Where("report_date_range_end >= ?", query.From.UTC())
+	}
+
+	if !query.To.IsZero() {
+		tx = tx.Where("report_date_range_begin <= ?", query.To.UTC())
+	}
+
+	if query.HasFailures != nil {
+		if *query.HasFailures {
+			tx = tx.Where("EXISTS (" + failedRecordsQuery + ")")
+		} else {
+			tx = tx.Where("NOT EXISTS (" + failedRecordsQuery + ")")
+		}
+	}
+
+	direction, operator := "ASC", ">"
+	if query.Descending {
+		direction, operator = "DESC", "<"
+	}
+
+	if query.Conflicting {
+		tx = tx.Where("conflict = ?", true)
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeReportCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := cursorValue(query.Sort, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		tx = tx.Where(
+			fmt.Sprintf("(%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?)", column, operator),
+			value, value, cursor.ID,
+		)
+	}
+
+	// Fetch one more than the limit, to know if there is a next page
+	models := []*ReportModel{}
+	err := tx.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(query.Limit + 1).
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &database.ReportPage{Reports: []*parsers.Report{}}
+	if len(models) > query.Limit {
+		models = models[:query.Limit]
+		page.NextCursor = encodeReportCursor(query.Sort, models[len(models)-1])
+	}
+
+	for _, model := range models {
+		page.Reports = append(page.Reports, ModelToReport(model, nil))
+	}
+
+	return page, nil
+}
+
+func encodeReportCursor(sort string, model *ReportModel) string {
+	cursor := reportCursor{ID: model.ID}
+
+	switch sort {
+	case database.SortDateRangeBegin:
+		cursor.Value = model.ReportDateRangeBegin.UTC().Format(time.RFC3339Nano)
+	case database.SortDateRangeEnd:
+		cursor.Value = model.ReportDateRangeEnd.UTC().Format(time.RFC3339Nano)
+	case database.SortOrgName:
+		cursor.Value = model.ReportMetadataOrgName
+	case database.SortDomain:
+		cursor.Value = model.PolicyPublishedDomain
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeReportCursor(encoded string) (*reportCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	cursor := &reportCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// cursorValue converts the cursor value back to the type of the sort column
+func cursorValue(sort string, value string) (interface{}, error) {
+	if sort != database.SortDateRangeBegin && sort != database.SortDateRangeEnd {
+		return value, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return t.UTC(), nil
+}
@@ -0,0 +1,150 @@
+package database_sqlite
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+// newQueryTestStorage stores reports of several reporters, domains and dates.
+// Sorted by date range begin they are:
+//   - valid4reportid: Yahoo, 2022-08-17, failures
+//   - valid3reportid: cisco.com, 2022-12-05
+//   - otherreportid: cisco.com, 2022-12-05, for other.example
+//   - valid1reportid: Yahoo, 2023-01-13, failures
+//   - valid2reportid: AMAZON-SES, 2023-03-16
+//   - valid5reportid: google.com, 2023-09-24, failures
+func newQueryTestStorage(t *testing.T) *SqliteStorage {
+	t.Helper()
+
+	store := newTestStorage(t)
+	loadTestReports(t, store, "valid1.xml", "valid2.xml", "valid3.xml", "valid4.xml", "valid5.xml")
+
+	other := readTestReport(t, "valid3.xml", func(data string) string {
+		data = strings.Replace(data, "valid3reportid", "otherreportid", 1)
+		return strings.Replace(data, "<domain>example.com</domain>", "<domain>Other.Example.</domain>", 1)
+	})
+	if err := store.CreateReport(other); err != nil {
+		t.Fatalf("failed to store report: %s", err)
+	}
+
+	return store
+}
+
+// queryReportIDs runs a query and returns the report IDs of the page
+func queryReportIDs(t *testing.T, store *SqliteStorage, query database.ReportQuery) ([]string, string) {
+	t.Helper()
+
+	page, err := store.QueryReports(query)
+	if err != nil {
+		t.Fatalf("failed to query reports: %s", err)
+	}
+
+	ids := []string{}
+	for _, report := range page.Reports {
+		ids = append(ids, report.ReportMetadata.ReportID)
+	}
+
+	return ids, page.NextCursor
+}
+
+func TestQueryReportsFilters(t *testing.T) {
+	store := newQueryTestStorage(t)
+	failures, noFailures := true, false
+
+	tests := map[string]struct {
+		query    database.ReportQuery
+		expected []string
+	}{
+		"all": {database.ReportQuery{}, []string{"valid4reportid", "valid3reportid", "otherreportid", "valid1reportid", "valid2reportid", "valid5reportid"}},
+		// The domain is matched in its canonical form
+		"domain":     {database.ReportQuery{Domain: "OTHER.example"}, []string{"otherreportid"}},
+		"org name":   {database.ReportQuery{OrgName: "Yahoo"}, []string{"valid4reportid", "valid1reportid"}},
+		"unknown":    {database.ReportQuery{OrgName: "yahoo"}, []string{}},
+		"report id":  {database.ReportQuery{ReportID: "valid3reportid"}, []string{"valid3reportid"}},
+		"date range": {database.ReportQuery{From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC)}, []string{"valid1reportid", "valid2reportid"}},
+		// Reports overlapping the ends of the range are included
+		"date range overlap": {database.ReportQuery{From: time.Unix(1673654399, 0), To: time.Unix(1678924800, 0)}, []string{"valid1reportid", "valid2reportid"}},
+		"open end":           {database.ReportQuery{From: time.Unix(1678924800, 0)}, []string{"valid2reportid", "valid5reportid"}},
+		"failures":           {database.ReportQuery{HasFailures: &failures}, []string{"valid4reportid", "valid1reportid", "valid5reportid"}},
+		"no failures":        {database.ReportQuery{HasFailures: &noFailures}, []string{"valid3reportid", "otherreportid", "valid2reportid"}},
+		"combined":           {database.ReportQuery{Domain: "example.com", OrgName: "cisco.com", HasFailures: &noFailures}, []string{"valid3reportid"}},
+	}
+
+	for name, test := range tests {
+		ids, cursor := queryReportIDs(t, store, test.query)
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, ids)
+		}
+		if cursor != "" {
+			t.Errorf("%s: expected no next page, got cursor %q", name, cursor)
+		}
+	}
+}
+
+func TestQueryReportsPagination(t *testing.T) {
+	store := newQueryTestStorage(t)
+
+	tests := map[string]struct {
+		query    database.ReportQuery
+		expected [][]string
+	}{
+		// valid3reportid and otherreportid have the same date, the cursor tells them apart
+		"date range begin": {
+			database.ReportQuery{Limit: 2},
+			[][]string{{"valid4reportid", "valid3reportid"}, {"otherreportid", "valid1reportid"}, {"valid2reportid", "valid5reportid"}},
+		},
+		"date range begin descending": {
+			database.ReportQuery{Descending: true, Limit: 4},
+			[][]string{{"valid5reportid", "valid2reportid", "valid1reportid", "otherreportid"}, {"valid3reportid", "valid4reportid"}},
+		},
+		// Names are sorted by their bytes, upper case first
+		"org name": {
+			database.ReportQuery{Sort: database.SortOrgName, Limit: 3},
+			[][]string{{"valid2reportid", "valid1reportid", "valid4reportid"}, {"valid3reportid", "otherreportid", "valid5reportid"}},
+		},
+		"domain descending": {
+			database.ReportQuery{Sort: database.SortDomain, Descending: true, Limit: 5},
+			[][]string{{"otherreportid", "valid5reportid", "valid4reportid", "valid3reportid", "valid2reportid"}, {"valid1reportid"}},
+		},
+		"date range end with filter": {
+			database.ReportQuery{Sort: database.SortDateRangeEnd, OrgName: "Yahoo", Limit: 1},
+			[][]string{{"valid4reportid"}, {"valid1reportid"}},
+		},
+	}
+
+	for name, test := range tests {
+		pages := [][]string{}
+		query := test.query
+		for {
+			ids, cursor := queryReportIDs(t, store, query)
+			pages = append(pages, ids)
+			if cursor == "" || len(pages) > len(test.expected) {
+				break
+			}
+			query.Cursor = cursor
+		}
+
+		if !reflect.DeepEqual(pages, test.expected) {
+			t.Errorf("%s: expected pages %v, got %v", name, test.expected, pages)
+		}
+	}
+}
+
+func TestQueryReportsInvalid(t *testing.T) {
+	store := newQueryTestStorage(t)
+
+	for name, query := range map[string]database.ReportQuery{
+		"sort":         {Sort: "report_id"},
+		"cursor":       {Cursor: "not a cursor"},
+		"cursor value": {Cursor: encodeReportCursor(database.SortOrgName, &ReportModel{ReportMetadataOrgName: "Yahoo"})},
+	} {
+		if _, err := store.QueryReports(query); !errors.Is(err, database.ErrInvalidQuery) {
+			t.Errorf("%s: expected an invalid query error, got %v", name, err)
+		}
+	}
+}
@@ -4,34 +4,39 @@ import (
 	"errors"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
 	"gorm.io/gorm"
 )
 
 type ReportRecordModel struct {
-	ID                         uint   `gorm:"primaryKey"`
-	CreatedAt                  int64  `gorm:"autoCreateTime"`
-	ReportID                   string `gorm:"foreignKey:ReportID"`
+	ID                         uint  `gorm:"primaryKey"`
+	CreatedAt                  int64 `gorm:"autoCreateTime"`
+	ReportModelID              uint  `gorm:"index"`
 	SourceIP                   string
 	Count                      int
 	PolicyEvaluatedDisposition string
 	PolicyEvaluatedDKIM        string
 	PolicyEvaluatedSPF         string
+	PolicyEvaluatedReasons     []ReportRecordReasonModel `gorm:"foreignKey:ReportRecordID;constraint:OnDelete:CASCADE"`
 	IdentifiersHeaderFrom      string
 	IdentifiersEnvelopeFrom    string
 	IdentifiersEnvelopeTo      string
-	AuthResultsDKIMDomain      string
-	AuthResultsDKIMResult      string
-	AuthResultsDKIMSelector    string
-	AuthResultsDKIMHumanResult string
-	AuthResultsSPFDomain       string
-	AuthResultsSPFResult       string
-	AuthResultsSPFScope        string
-	AuthResultsSPFHumanResult  string
+	// The identifiers as sent, when they were not in their canonical form
+	IdentifiersOriginalHeaderFrom   string
+	IdentifiersOriginalEnvelopeFrom string
+	IdentifiersOriginalEnvelopeTo   string
+	AuthResultsDKIM                 []ReportRecordDKIMModel      `gorm:"foreignKey:ReportRecordID;constraint:OnDelete:CASCADE"`
+	AuthResultsSPF                  []ReportRecordSPFModel       `gorm:"foreignKey:ReportRecordID;constraint:OnDelete:CASCADE"`
+	Issues                          []ReportRecordIssueModel     `gorm:"foreignKey:ReportRecordID;constraint:OnDelete:CASCADE"`
+	Extensions                      []ReportRecordExtensionModel `gorm:"foreignKey:ReportRecordID;constraint:OnDelete:CASCADE"`
 }
 
-func (s *SqliteStorage) CreateReportRecord(reportID string, record *parsers.Record) error {
-	r := ReportRecordToModel(reportID, record)
+func (s *SqliteStorage) CreateReportRecord(reportModelID uint, record *parsers.Record) error {
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
+	r := ReportRecordToModel(reportModelID, record)
 	err := s.db.Create(r).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -45,17 +50,54 @@ func (s *SqliteStorage) CreateReportRecord(reportID string, record *parsers.Reco
 	return nil
 }
 
-func (s *SqliteStorage) FindRecordsByReportID(reportID string) ([]*parsers.Record, error) {
-	reportRecordModels := []*ReportRecordModel{}
+// FindRecordsByReportID returns the records of the report of a reporter with the given report ID.
+// If conflicting versions of the report exist, the records of the first one stored are returned.
+func (s *SqliteStorage) FindRecordsByReportID(orgName string, reportID string) ([]*parsers.Record, error) {
+	report := &ReportModel{}
 
-	if err := s.db.Where("report_id = ?", reportID).Find(&reportRecordModels).Error; err != nil {
+	err := s.db.Select("id").
+		Where("report_metadata_org_name = ? AND report_id = ?", orgName, reportID).
+		Order("id").
+		First(report).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, database.ErrReportNotFound
+		}
 		return nil, err
 	}
 
-	records := make([]*parsers.Record, len(reportRecordModels))
-	for idx, record := range reportRecordModels {
-		// Convert the ReportRecordModel to a parsers.Record
-		records[idx] = ModelToReportRecord(record)
+	records, err := s.findRecordsByReportModelIDs([]uint{report.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return records[report.ID], nil
+}
+
+// recordsBatchSize keeps the IN clauses below the SQLite variable limit
+const recordsBatchSize = 500
+
+// findRecordsByReportModelIDs loads the records of multiple reports,
+// with a constant number of queries per batch of reports
+func (s *SqliteStorage) findRecordsByReportModelIDs(ids []uint) (map[uint][]*parsers.Record, error) {
+	records := make(map[uint][]*parsers.Record, len(ids))
+
+	for start := 0; start < len(ids); start += recordsBatchSize {
+		end := min(start+recordsBatchSize, len(ids))
+
+		reportRecordModels := []*ReportRecordModel{}
+		err := s.preloadRecordAssociations().
+			Where("report_model_id IN ?", ids[start:end]).
+			Order("id").
+			Find(&reportRecordModels).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range reportRecordModels {
+			// Convert the ReportRecordModel to a parsers.Record
+			records[record.ReportModelID] = append(records[record.ReportModelID], ModelToReportRecord(record))
+		}
 	}
 
 	return records, nil
@@ -64,7 +106,7 @@ func (s *SqliteStorage) FindRecordsByReportID(reportID string) ([]*parsers.Recor
 func (s *SqliteStorage) FindRecords() ([]*parsers.Record, error) {
 	reportRecordModels := []*ReportRecordModel{}
 
-	if err := s.db.Find(&reportRecordModels).Error; err != nil {
+	if err := s.preloadRecordAssociations().Find(&reportRecordModels).Error; err != nil {
 		return nil, err
 	}
 
@@ -77,26 +119,37 @@ func (s *SqliteStorage) FindRecords() ([]*parsers.Record, error) {
 	return records, nil
 }
 
+// preloadRecordAssociations returns a query that loads the policy override
+// reasons, the DKIM and SPF results, the issues and the extensions of each record
+func (s *SqliteStorage) preloadRecordAssociations() *gorm.DB {
+	return s.db.
+		Preload("PolicyEvaluatedReasons").
+		Preload("AuthResultsDKIM").
+		Preload("AuthResultsSPF").
+		Preload("Issues").
+		Preload("Extensions")
+}
+
 // Converts a parsers.Record to a ReportRecordModel
-func ReportRecordToModel(reportID string, rec *parsers.Record) *ReportRecordModel {
+func ReportRecordToModel(reportModelID uint, rec *parsers.Record) *ReportRecordModel {
 	return &ReportRecordModel{
-		ReportID:                   reportID,
-		SourceIP:                   rec.Row.SourceIP,
-		Count:                      rec.Row.Count,
-		PolicyEvaluatedDisposition: rec.Row.PolicyEvaluated.Disposition,
-		PolicyEvaluatedDKIM:        rec.Row.PolicyEvaluated.DKIM,
-		PolicyEvaluatedSPF:         rec.Row.PolicyEvaluated.SPF,
-		IdentifiersHeaderFrom:      rec.Identifiers.HeaderFrom,
-		IdentifiersEnvelopeFrom:    rec.Identifiers.EnvelopeFrom,
-		IdentifiersEnvelopeTo:      rec.Identifiers.EnvelopeTo,
-		AuthResultsDKIMDomain:      rec.AuthResults.DKIM.Domain,
-		AuthResultsDKIMResult:      rec.AuthResults.DKIM.Result,
-		AuthResultsDKIMSelector:    rec.AuthResults.DKIM.Selector,
-		AuthResultsDKIMHumanResult: rec.AuthResults.DKIM.HumanResult,
-		AuthResultsSPFDomain:       rec.AuthResults.SPF.Domain,
-		AuthResultsSPFResult:       rec.AuthResults.SPF.Result,
-		AuthResultsSPFScope:        rec.AuthResults.SPF.Scope,
-		AuthResultsSPFHumanResult:  rec.AuthResults.SPF.HumanResult,
+		ReportModelID:                   reportModelID,
+		SourceIP:                        rec.Row.SourceIP,
+		Count:                           rec.Row.Count,
+		PolicyEvaluatedDisposition:      rec.Row.PolicyEvaluated.Disposition,
+		PolicyEvaluatedDKIM:             rec.Row.PolicyEvaluated.DKIM,
+		PolicyEvaluatedSPF:              rec.Row.PolicyEvaluated.SPF,
+		PolicyEvaluatedReasons:          PolicyOverrideReasonsToModel(rec.Row.PolicyEvaluated.Reasons),
+		IdentifiersHeaderFrom:           rec.Identifiers.HeaderFrom,
+		IdentifiersEnvelopeFrom:         rec.Identifiers.EnvelopeFrom,
+		IdentifiersEnvelopeTo:           rec.Identifiers.EnvelopeTo,
+		IdentifiersOriginalHeaderFrom:   rec.Identifiers.OriginalHeaderFrom,
+		IdentifiersOriginalEnvelopeFrom: rec.Identifiers.OriginalEnvelopeFrom,
+		IdentifiersOriginalEnvelopeTo:   rec.Identifiers.OriginalEnvelopeTo,
+		AuthResultsDKIM:                 DKIMAuthResultsToModel(rec.AuthResults.DKIM),
+		AuthResultsSPF:                  SPFAuthResultsToModel(rec.AuthResults.SPF),
+		Issues:                          ValidationErrorsToModel(rec.Issues),
+		Extensions:                      RecordExtensionsToModel(rec.Extensions),
 	}
 }
 
@@ -110,26 +163,22 @@ func ModelToReportRecord(r *ReportRecordModel) *parsers.Record {
 				Disposition: r.PolicyEvaluatedDisposition,
 				DKIM:        r.PolicyEvaluatedDKIM,
 				SPF:         r.PolicyEvaluatedSPF,
+				Reasons:     ModelToPolicyOverrideReasons(r.PolicyEvaluatedReasons),
 			},
 		},
 		Identifiers: parsers.Identifiers{
-			HeaderFrom:   r.IdentifiersHeaderFrom,
-			EnvelopeFrom: r.IdentifiersEnvelopeFrom,
-			EnvelopeTo:   r.IdentifiersEnvelopeTo,
+			HeaderFrom:           r.IdentifiersHeaderFrom,
+			EnvelopeFrom:         r.IdentifiersEnvelopeFrom,
+			EnvelopeTo:           r.IdentifiersEnvelopeTo,
+			OriginalHeaderFrom:   r.IdentifiersOriginalHeaderFrom,
+			OriginalEnvelopeFrom: r.IdentifiersOriginalEnvelopeFrom,
+			OriginalEnvelopeTo:   r.IdentifiersOriginalEnvelopeTo,
 		},
 		AuthResults: parsers.AuthResult{
-			DKIM: parsers.DKIMAuthResult{
-				Domain:      r.AuthResultsDKIMDomain,
-				Result:      r.AuthResultsDKIMResult,
-				Selector:    r.AuthResultsDKIMSelector,
-				HumanResult: r.AuthResultsDKIMHumanResult,
-			},
-			SPF: parsers.SPFAuthResult{
-				Domain:      r.AuthResultsSPFDomain,
-				Result:      r.AuthResultsSPFResult,
-				Scope:       r.AuthResultsSPFScope,
-				HumanResult: r.AuthResultsSPFHumanResult,
-			},
+			DKIM: ModelToDKIMAuthResults(r.AuthResultsDKIM),
+			SPF:  ModelToSPFAuthResults(r.AuthResultsSPF),
 		},
+		Issues:     ModelToValidationErrors(r.Issues),
+		Extensions: ModelToRecordExtensions(r.Extensions),
 	}
 }
@@ -0,0 +1,95 @@
+package database_sqlite
+
+import (
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// ReportRecordDKIMModel holds a single DKIM signature evaluated for a record
+type ReportRecordDKIMModel struct {
+	ID             uint  `gorm:"primaryKey"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	ReportRecordID uint  `gorm:"index"`
+	Domain         string
+	// OriginalDomain is the domain as sent, when it was not in its canonical form
+	OriginalDomain string
+	Selector       string
+	Result         string
+	HumanResult    string
+}
+
+// ReportRecordSPFModel holds a single SPF check evaluated for a record
+type ReportRecordSPFModel struct {
+	ID             uint  `gorm:"primaryKey"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	ReportRecordID uint  `gorm:"index"`
+	Domain         string
+	// OriginalDomain is the domain as sent, when it was not in its canonical form
+	OriginalDomain string
+	Scope          string
+	Result         string
+	HumanResult    string
+}
+
+// Converts a slice of parsers.DKIMAuthResult to a slice of ReportRecordDKIMModel
+func DKIMAuthResultsToModel(results []parsers.DKIMAuthResult) []ReportRecordDKIMModel {
+	models := make([]ReportRecordDKIMModel, len(results))
+	for idx, result := range results {
+		models[idx] = ReportRecordDKIMModel{
+			Domain:         result.Domain,
+			OriginalDomain: result.OriginalDomain,
+			Selector:       result.Selector,
+			Result:         result.Result,
+			HumanResult:    result.HumanResult,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportRecordDKIMModel to a slice of parsers.DKIMAuthResult
+func ModelToDKIMAuthResults(models []ReportRecordDKIMModel) []parsers.DKIMAuthResult {
+	results := make([]parsers.DKIMAuthResult, len(models))
+	for idx, model := range models {
+		results[idx] = parsers.DKIMAuthResult{
+			Domain:         model.Domain,
+			OriginalDomain: model.OriginalDomain,
+			Selector:       model.Selector,
+			Result:         model.Result,
+			HumanResult:    model.HumanResult,
+		}
+	}
+
+	return results
+}
+
+// Converts a slice of parsers.SPFAuthResult to a slice of ReportRecordSPFModel
+func SPFAuthResultsToModel(results []parsers.SPFAuthResult) []ReportRecordSPFModel {
+	models := make([]ReportRecordSPFModel, len(results))
+	for idx, result := range results {
+		models[idx] = ReportRecordSPFModel{
+			Domain:         result.Domain,
+			OriginalDomain: result.OriginalDomain,
+			Scope:          result.Scope,
+			Result:         result.Result,
+			HumanResult:    result.HumanResult,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportRecordSPFModel to a slice of parsers.SPFAuthResult
+func ModelToSPFAuthResults(models []ReportRecordSPFModel) []parsers.SPFAuthResult {
+	results := make([]parsers.SPFAuthResult, len(models))
+	for idx, model := range models {
+		results[idx] = parsers.SPFAuthResult{
+			Domain:         model.Domain,
+			OriginalDomain: model.OriginalDomain,
+			Scope:          model.Scope,
+			Result:         model.Result,
+			HumanResult:    model.HumanResult,
+		}
+	}
+
+	return results
+}
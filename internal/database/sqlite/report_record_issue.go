@@ -0,0 +1,55 @@
+package database_sqlite
+
+import (
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// ReportRecordIssueModel holds a single validation problem of a record,
+// for records that were kept despite failing validation
+type ReportRecordIssueModel struct {
+	ID             uint  `gorm:"primaryKey"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	ReportRecordID uint  `gorm:"index"`
+	Path           string
+	Field          string
+	Value          string
+	Rule           string
+	Message        string
+}
+
+// Converts a slice of parsers.ValidationError to a slice of ReportRecordIssueModel
+func ValidationErrorsToModel(issues []parsers.ValidationError) []ReportRecordIssueModel {
+	models := make([]ReportRecordIssueModel, len(issues))
+	for idx, issue := range issues {
+		models[idx] = ReportRecordIssueModel{
+			Path:    issue.Path,
+			Field:   issue.Field,
+			Value:   issue.Value,
+			Rule:    issue.Rule,
+			Message: issue.Message,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportRecordIssueModel to a slice of parsers.ValidationError
+func ModelToValidationErrors(models []ReportRecordIssueModel) []parsers.ValidationError {
+	// Valid records have no issues, keep the field omitted from the JSON
+	if len(models) == 0 {
+		return nil
+	}
+
+	issues := make([]parsers.ValidationError, len(models))
+	for idx, model := range models {
+		issues[idx] = parsers.ValidationError{
+			Path:    model.Path,
+			Field:   model.Field,
+			Value:   model.Value,
+			Rule:    model.Rule,
+			Message: model.Message,
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,41 @@
+package database_sqlite
+
+import (
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// ReportRecordReasonModel holds a single policy override reason given by
+// the receiver for the disposition of a record
+type ReportRecordReasonModel struct {
+	ID             uint  `gorm:"primaryKey"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	ReportRecordID uint  `gorm:"index"`
+	Type           string
+	Comment        string
+}
+
+// Converts a slice of parsers.PolicyOverrideReason to a slice of ReportRecordReasonModel
+func PolicyOverrideReasonsToModel(reasons []parsers.PolicyOverrideReason) []ReportRecordReasonModel {
+	models := make([]ReportRecordReasonModel, len(reasons))
+	for idx, reason := range reasons {
+		models[idx] = ReportRecordReasonModel{
+			Type:    reason.Type,
+			Comment: reason.Comment,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportRecordReasonModel to a slice of parsers.PolicyOverrideReason
+func ModelToPolicyOverrideReasons(models []ReportRecordReasonModel) []parsers.PolicyOverrideReason {
+	reasons := make([]parsers.PolicyOverrideReason, len(models))
+	for idx, model := range models {
+		reasons[idx] = parsers.PolicyOverrideReason{
+			Type:    model.Type,
+			Comment: model.Comment,
+		}
+	}
+
+	return reasons
+}
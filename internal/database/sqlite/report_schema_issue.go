@@ -0,0 +1,55 @@
+package database_sqlite
+
+import (
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// ReportSchemaIssueModel holds a single problem of a report that
+// does not conform to the schema, used to grade reporters
+type ReportSchemaIssueModel struct {
+	ID            uint  `gorm:"primaryKey"`
+	CreatedAt     int64 `gorm:"autoCreateTime"`
+	ReportModelID uint  `gorm:"index"`
+	Path          string
+	Field         string
+	Value         string
+	Rule          string
+	Message       string
+}
+
+// Converts a slice of parsers.ValidationError to a slice of ReportSchemaIssueModel
+func SchemaIssuesToModel(issues []parsers.ValidationError) []ReportSchemaIssueModel {
+	models := make([]ReportSchemaIssueModel, len(issues))
+	for idx, issue := range issues {
+		models[idx] = ReportSchemaIssueModel{
+			Path:    issue.Path,
+			Field:   issue.Field,
+			Value:   issue.Value,
+			Rule:    issue.Rule,
+			Message: issue.Message,
+		}
+	}
+
+	return models
+}
+
+// Converts a slice of ReportSchemaIssueModel to a slice of parsers.ValidationError
+func ModelToSchemaIssues(models []ReportSchemaIssueModel) []parsers.ValidationError {
+	// Conforming reports have no issues, keep the field omitted from the JSON
+	if len(models) == 0 {
+		return nil
+	}
+
+	issues := make([]parsers.ValidationError, len(models))
+	for idx, model := range models {
+		issues[idx] = parsers.ValidationError{
+			Path:    model.Path,
+			Field:   model.Field,
+			Value:   model.Value,
+			Rule:    model.Rule,
+			Message: model.Message,
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,263 @@
+package database_sqlite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// newTestDecoder opens a testdata file for streaming, after applying edit to its content
+func newTestDecoder(t *testing.T, name string, mode parsers.ValidationMode, edit func(string) string) *parsers.ReportDecoder {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", name, err)
+	}
+
+	decoder, err := parsers.NewReportDecoder(strings.NewReader(edit(string(data))), parsers.Options{Validation: mode})
+	if err != nil {
+		t.Fatalf("failed to decode %s: %s", name, err)
+	}
+
+	return decoder
+}
+
+func unchanged(data string) string { return data }
+
+func TestCreateReportStream(t *testing.T) {
+	store := newTestStorage(t)
+
+	if err := store.CreateReportStream(newTestDecoder(t, "extensions.xml", parsers.ValidationLenient, unchanged)); err != nil {
+		t.Fatalf("failed to store report: %s", err)
+	}
+
+	// A streamed report is stored as the same report parsed in memory
+	expected := readTestReport(t, "extensions.xml", unchanged)
+	stored, err := store.FindReportByHash(expected.ContentHash)
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+	if !reflect.DeepEqual(normalizeReport(expected), stored) {
+		t.Errorf("stored report does not match\nwant: %+v\ngot:  %+v", expected, stored)
+	}
+
+	// Resends are found once the stream is read, in memory or streamed
+	if err := store.CreateReport(expected); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error, got %v", err)
+	}
+	if err := store.CreateReportStream(newTestDecoder(t, "extensions.xml", parsers.ValidationLenient, unchanged)); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error, got %v", err)
+	}
+
+	// Elements after the records are stored with the report
+	trailing := func(data string) string {
+		return strings.Replace(data, "</feedback>", "<extension><vendor:done/></extension></feedback>", 1)
+	}
+	if err := store.CreateReportStream(newTestDecoder(t, "extensions.xml", parsers.ValidationLenient, trailing)); !errors.Is(err, database.ErrConflictingReport) {
+		t.Fatalf("expected a conflicting report error, got %v", err)
+	}
+
+	conflicting := readTestReport(t, "extensions.xml", trailing)
+	stored, err = store.FindReportByHash(conflicting.ContentHash)
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+	if !stored.Conflict || len(stored.Extensions) != 2 || stored.Extensions[1].Name != "extension" {
+		t.Errorf("expected a conflicting report with 2 extensions, got %t and %+v", stored.Conflict, stored.Extensions)
+	}
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected 2 reports, got %d", len(reports))
+	}
+}
+
+func TestCreateReportStreamRollsBack(t *testing.T) {
+	// Past the buffered records, the invalid record is only found after the first ones are inserted
+	large := generateReport(maxBufferedRecords + 10)
+	last := bytes.LastIndex(large, []byte("<scope>mfrom</scope>"))
+	large = append(large[:last:last], append([]byte("<scope>envelope</scope>"), large[last+len("<scope>mfrom</scope>"):]...)...)
+
+	tests := map[string]struct {
+		decoder  *parsers.ReportDecoder
+		reportID string
+	}{
+		"buffered": {newTestDecoder(t, "invalid_record.xml", parsers.ValidationStrict, unchanged), "invalidrecordreportid"},
+		"streamed": {newStrictDecoder(t, large), "generated"},
+	}
+
+	for name, test := range tests {
+		store := newTestStorage(t)
+		err := store.CreateReportStream(test.decoder)
+
+		errs := parsers.ValidationErrors{}
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: expected validation errors, got %v", name, err)
+		}
+
+		records, err := store.FindRecords()
+		if err != nil {
+			t.Fatalf("%s: failed to find records: %s", name, err)
+		}
+		if len(records) != 0 {
+			t.Errorf("%s: expected no records to be stored, got %d", name, len(records))
+		}
+
+		if _, err := store.FindReportByReportID("example.net", test.reportID); !errors.Is(err, database.ErrReportNotFound) {
+			t.Errorf("%s: expected the report not to be stored, got %v", name, err)
+		}
+	}
+}
+
+func newStrictDecoder(t *testing.T, data []byte) *parsers.ReportDecoder {
+	t.Helper()
+
+	decoder, err := parsers.NewReportDecoder(bytes.NewReader(data), parsers.Options{Validation: parsers.ValidationStrict})
+	if err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+
+	return decoder
+}
+
+// blockingStream blocks reading its records until released
+type blockingStream struct {
+	database.ReportStream
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStream) Next(n int) ([]parsers.Record, error) {
+	if b.reading != nil {
+		close(b.reading)
+		b.reading = nil
+		<-b.release
+	}
+
+	return b.ReportStream.Next(n)
+}
+
+func TestCreateReportStreamConcurrently(t *testing.T) {
+	store := newTestStorage(t)
+
+	stream := &blockingStream{
+		ReportStream: newTestDecoder(t, "valid1.xml", parsers.ValidationLenient, unchanged),
+		reading:      make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	reading := stream.reading
+
+	stored := make(chan error)
+	go func() {
+		stored <- store.CreateReportStream(stream)
+	}()
+
+	// Other reports are written while a stream is read
+	<-reading
+	done := make(chan error)
+	go func() {
+		done <- store.CreateReport(readTestReport(t, "valid2.xml", unchanged))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("failed to store report: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the report to be stored while the stream is read")
+		close(stream.release)
+		<-done
+		<-stored
+		return
+	}
+
+	close(stream.release)
+	if err := <-stored; err != nil {
+		t.Fatalf("failed to store the streamed report: %s", err)
+	}
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected 2 reports, got %d", len(reports))
+	}
+}
+
+// generateReport builds a report with the given number of records
+func generateReport(records int) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" ?>
+<feedback>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>generated</report_id>
+    <date_range><begin>1695513600</begin><end>1695599999</end></date_range>
+  </report_metadata>
+  <policy_published><domain>example.com</domain><p>none</p></policy_published>
+`)
+
+	for idx := 0; idx < records; idx++ {
+		fmt.Fprintf(&buf, `  <record>
+    <row>
+      <source_ip>10.%d.%d.%d</source_ip>
+      <count>%d</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results>
+      <dkim><domain>example.com</domain><selector>s1</selector><result>pass</result></dkim>
+      <spf><domain>example.com</domain><scope>mfrom</scope><result>fail</result></spf>
+    </auth_results>
+  </record>
+`, idx>>16&0xff, idx>>8&0xff, idx&0xff, idx%50+1)
+	}
+
+	buf.WriteString("</feedback>\n")
+
+	return buf.Bytes()
+}
+
+func BenchmarkCreateReportStream(b *testing.B) {
+	data := generateReport(100_000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store, err := NewSqliteStorage(filepath.Join(b.TempDir(), "dmarc.db"))
+		if err != nil {
+			b.Fatalf("failed to create storage: %s", err)
+		}
+		if err := store.Migrate(); err != nil {
+			b.Fatalf("failed to migrate storage: %s", err)
+		}
+		b.StartTimer()
+
+		decoder, err := parsers.NewReportDecoder(bytes.NewReader(data), parsers.Options{Validation: parsers.ValidationLenient})
+		if err != nil {
+			b.Fatalf("failed to decode report: %s", err)
+		}
+
+		if err := store.CreateReportStream(decoder); err != nil {
+			b.Fatalf("failed to store report: %s", err)
+		}
+	}
+}
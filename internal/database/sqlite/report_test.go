@@ -0,0 +1,331 @@
+package database_sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"gorm.io/gorm"
+)
+
+var validReports = []string{"valid1.xml", "valid2.xml", "valid3.xml", "valid4.xml", "valid5.xml", "valid6.xml", "dmarcbis.xml", "extensions.xml", "unnormalized.xml"}
+
+// newTestStorage creates a migrated storage in a temporary directory
+func newTestStorage(t *testing.T) *SqliteStorage {
+	t.Helper()
+
+	store, err := NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	return store
+}
+
+// loadTestReports parses the given testdata files and stores them
+func loadTestReports(t *testing.T, store *SqliteStorage, names ...string) map[string]*parsers.Report {
+	t.Helper()
+
+	reports := map[string]*parsers.Report{}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+
+		report, err := parsers.NewReport(data)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", name, err)
+		}
+
+		if err := store.CreateReport(report); err != nil {
+			t.Fatalf("failed to store %s: %s", name, err)
+		}
+
+		reports[report.ReportMetadata.ReportID] = report
+	}
+
+	return reports
+}
+
+// countQueries counts the queries executed on the storage until the returned function is called
+func countQueries(t *testing.T, store *SqliteStorage) func() int {
+	t.Helper()
+
+	count := 0
+	name := "test:count_queries"
+	err := store.db.Callback().Query().After("gorm:query").Register(name, func(*gorm.DB) {
+		count++
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %s", err)
+	}
+
+	return func() int {
+		store.db.Callback().Query().Remove(name)
+		return count
+	}
+}
+
+func TestFindReports(t *testing.T) {
+	store := newTestStorage(t)
+	expected := loadTestReports(t, store, validReports...)
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+
+	if len(reports) != len(expected) {
+		t.Fatalf("expected %d reports, got %d", len(expected), len(reports))
+	}
+
+	for _, report := range reports {
+		want, ok := expected[report.ReportMetadata.ReportID]
+		if !ok {
+			t.Errorf("unexpected report %s", report.ReportMetadata.ReportID)
+			continue
+		}
+
+		if !reflect.DeepEqual(normalizeReport(want), normalizeReport(report)) {
+			t.Errorf("report %s does not match the stored report\nwant: %+v\ngot:  %+v", report.ReportMetadata.ReportID, want, report)
+		}
+	}
+}
+
+func TestFindReportsQueryCount(t *testing.T) {
+	// The number of queries must not grow with the number of reports
+	queries := func(names ...string) int {
+		store := newTestStorage(t)
+		loadTestReports(t, store, names...)
+
+		done := countQueries(t, store)
+		if _, err := store.FindReports(); err != nil {
+			t.Fatalf("failed to find reports: %s", err)
+		}
+
+		return done()
+	}
+
+	one := queries(validReports[0])
+	all := queries(validReports...)
+	if one != all {
+		t.Errorf("expected the same number of queries for 1 and %d reports, got %d and %d", len(validReports), one, all)
+	}
+}
+
+func TestFindReportsEmpty(t *testing.T) {
+	store := newTestStorage(t)
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+
+	if len(reports) != 0 {
+		t.Errorf("expected no reports, got %d", len(reports))
+	}
+}
+
+func TestCreateReportIsAtomic(t *testing.T) {
+	store := newTestStorage(t)
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "valid5.xml"))
+	if err != nil {
+		t.Fatalf("failed to read report: %s", err)
+	}
+
+	report, err := parsers.NewReport(data)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	// Fail every insert of the DKIM results, after the report and records rows were inserted
+	name := "test:fail_dkim"
+	err = store.db.Callback().Create().Before("gorm:create").Register(name, func(db *gorm.DB) {
+		if db.Statement.Table == "report_record_dkim_models" {
+			db.AddError(errors.New("disk I/O error"))
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %s", err)
+	}
+
+	if err := store.CreateReport(report); err == nil {
+		t.Fatal("expected the report insert to fail")
+	}
+
+	for _, model := range []interface{}{&ReportModel{}, &ReportRecordModel{}, &ReportErrorModel{}, &ReportRecordReasonModel{}, &ReportRecordSPFModel{}} {
+		var count int64
+		if err := store.db.Model(model).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count %T: %s", model, err)
+		}
+		if count != 0 {
+			t.Errorf("expected no %T rows after a failed insert, got %d", model, count)
+		}
+	}
+
+	// Once the failure is gone, the report must not be treated as a duplicate
+	store.db.Callback().Create().Remove(name)
+
+	if err := store.CreateReport(report); err != nil {
+		t.Fatalf("failed to store report after a failed attempt: %s", err)
+	}
+
+	stored, err := store.FindReportByReportID(report.ReportMetadata.OrgName, report.ReportMetadata.ReportID)
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+
+	if !reflect.DeepEqual(normalizeReport(report), normalizeReport(stored)) {
+		t.Errorf("stored report does not match\nwant: %+v\ngot:  %+v", report, stored)
+	}
+
+	if err := store.CreateReport(report); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error, got %v", err)
+	}
+}
+
+func TestCreateReportIdentity(t *testing.T) {
+	store := newTestStorage(t)
+	stored := loadTestReports(t, store, "valid5.xml")["valid5reportid"]
+
+	// An exact resend is skipped
+	if err := store.CreateReport(stored); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error for a resend, got %v", err)
+	}
+
+	// The same report ID from another reporter is a different report
+	other := readTestReport(t, "valid5.xml", func(data string) string {
+		return strings.Replace(data, "<org_name>google.com</org_name>", "<org_name>example.net</org_name>", 1)
+	})
+	if err := store.CreateReport(other); err != nil {
+		t.Errorf("expected the report of another reporter to be stored, got %v", err)
+	}
+
+	// The same report ID from the same reporter with another content is a conflict
+	conflicting := readTestReport(t, "valid5.xml", func(data string) string {
+		return strings.Replace(data, "<count>", "<count>1", 1)
+	})
+	if err := store.CreateReport(conflicting); !errors.Is(err, database.ErrConflictingReport) {
+		t.Fatalf("expected a conflicting report error, got %v", err)
+	}
+
+	// Both versions are kept and flagged
+	for _, hash := range []string{stored.ContentHash, conflicting.ContentHash} {
+		report, err := store.FindReportByHash(hash)
+		if err != nil {
+			t.Fatalf("failed to find report %s: %s", hash, err)
+		}
+		if !report.Conflict {
+			t.Errorf("expected report %s to be flagged as conflicting", hash)
+		}
+	}
+
+	first, err := store.FindReportByReportID("google.com", "valid5reportid")
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+	if first.ContentHash != stored.ContentHash {
+		t.Errorf("expected the first stored version, got %s", first.ContentHash)
+	}
+
+	unflagged, err := store.FindReportByReportID("example.net", "valid5reportid")
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+	if unflagged.Conflict {
+		t.Error("expected the report of another reporter not to be flagged")
+	}
+
+	page, err := store.QueryReports(database.ReportQuery{Conflicting: true})
+	if err != nil {
+		t.Fatalf("failed to query reports: %s", err)
+	}
+	if len(page.Reports) != 2 {
+		t.Errorf("expected 2 conflicting reports, got %d", len(page.Reports))
+	}
+}
+
+func TestCreateReportSchemaIssues(t *testing.T) {
+	store := newTestStorage(t)
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "valid5.xml"))
+	if err != nil {
+		t.Fatalf("failed to read report: %s", err)
+	}
+
+	report, err := parsers.NewReportWithOptions(data, parsers.Options{Schema: parsers.SchemaWarn})
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	if len(report.SchemaIssues) == 0 {
+		t.Fatal("expected the report to have schema issues")
+	}
+
+	if err := store.CreateReport(report); err != nil {
+		t.Fatalf("failed to store report: %s", err)
+	}
+
+	stored, err := store.FindReportByHash(report.ContentHash)
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+
+	if !reflect.DeepEqual(report.SchemaIssues, stored.SchemaIssues) {
+		t.Errorf("expected the schema issues to be stored\nwant: %+v\ngot:  %+v", report.SchemaIssues, stored.SchemaIssues)
+	}
+}
+
+// readTestReport parses a testdata file after applying edit to its content
+func readTestReport(t *testing.T, name string, edit func(string) string) *parsers.Report {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", name, err)
+	}
+
+	report, err := parsers.NewReport([]byte(edit(string(data))))
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", name, err)
+	}
+
+	return report
+}
+
+// normalizeReport replaces nil slices with empty ones,
+// as the storage does not distinguish between the two
+func normalizeReport(r *parsers.Report) *parsers.Report {
+	report := *r
+	if report.ReportMetadata.Errors == nil {
+		report.ReportMetadata.Errors = []string{}
+	}
+
+	records := make([]parsers.Record, len(report.Records))
+	for idx, record := range report.Records {
+		if record.Row.PolicyEvaluated.Reasons == nil {
+			record.Row.PolicyEvaluated.Reasons = []parsers.PolicyOverrideReason{}
+		}
+		if record.AuthResults.DKIM == nil {
+			record.AuthResults.DKIM = []parsers.DKIMAuthResult{}
+		}
+		if record.AuthResults.SPF == nil {
+			record.AuthResults.SPF = []parsers.SPFAuthResult{}
+		}
+		records[idx] = record
+	}
+	report.Records = records
+
+	return &report
+}
@@ -1,8 +1,14 @@
 package database_sqlite
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -11,11 +17,23 @@ import (
 // SqliteStorage
 type SqliteStorage struct {
 	db *gorm.DB
+	// mutexWrite serializes the writes, SQLite allows a single writer at a time
+	// and concurrent inputs would otherwise fail with "database is locked"
+	mutexWrite sync.Mutex
 }
 
+// sqliteParams enable write-ahead logging, so reads are not blocked while a
+// report is written, and make connections wait for locks instead of failing
+const sqliteParams = "_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
+
 // NewSqliteStorage creates a new SqliteStorage
 func NewSqliteStorage(dbPath string) (*SqliteStorage, error) {
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+
+	db, err := gorm.Open(sqlite.Open(dbPath+separator+sqliteParams), &gorm.Config{
 		TranslateError: true,
 		Logger:         logger.Default.LogMode(logger.Silent),
 		NowFunc:        func() time.Time { return time.Now().UTC() },
@@ -29,11 +47,43 @@ func NewSqliteStorage(dbPath string) (*SqliteStorage, error) {
 	}, nil
 }
 
+// Close waits for the write in progress, if any, and closes the database
+func (s *SqliteStorage) Close() error {
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
+}
+
 // Migrate migrates the database
 func (s *SqliteStorage) Migrate() error {
+	// Checked before the new columns are added by the migrations
+	normalize := s.needsNormalization()
+
+	if err := s.migrateReportIdentity(); err != nil {
+		return err
+	}
+
 	models := []interface{}{
 		&ReportModel{},
+		&ReportErrorModel{},
+		&ReportSchemaIssueModel{},
+		&ReportExtensionModel{},
 		&ReportRecordModel{},
+		&ReportRecordReasonModel{},
+		&ReportRecordDKIMModel{},
+		&ReportRecordSPFModel{},
+		&ReportRecordIssueModel{},
+		&ReportRecordExtensionModel{},
+		&FailureReportModel{},
+		&TLSReportModel{},
+		&TLSPolicyModel{},
+		&TLSFailureDetailModel{},
 		&AddressModel{},
 	}
 
@@ -43,5 +93,244 @@ func (s *SqliteStorage) Migrate() error {
 		}
 	}
 
+	if err := s.migrateAuthResults(); err != nil {
+		return err
+	}
+
+	if normalize {
+		return s.migrateNormalization()
+	}
+
+	return nil
+}
+
+// legacyAuthResultColumns are the columns of the single DKIM and SPF result
+// records had before they could have several of each
+var legacyAuthResultColumns = []string{
+	"auth_results_dkim_domain", "auth_results_dkim_result", "auth_results_dkim_selector", "auth_results_dkim_human_result",
+	"auth_results_spf_domain", "auth_results_spf_result", "auth_results_spf_scope", "auth_results_spf_human_result",
+}
+
+// migrateAuthResults moves the DKIM and SPF result of the records stored when
+// records had a single one of each to their own tables. Records without a
+// result have an empty domain and result, and get none.
+func (s *SqliteStorage) migrateAuthResults() error {
+	if !s.db.Migrator().HasColumn(&ReportRecordModel{}, "auth_results_dkim_domain") {
+		return nil
+	}
+
+	log.Info("Migrating the DKIM and SPF results of records to their own tables")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"INSERT INTO report_record_dkim_models (created_at, report_record_id, domain, selector, result, human_result) " +
+				"SELECT created_at, id, COALESCE(auth_results_dkim_domain, ''), COALESCE(auth_results_dkim_selector, ''), " +
+				"COALESCE(auth_results_dkim_result, ''), COALESCE(auth_results_dkim_human_result, '') FROM report_record_models " +
+				"WHERE COALESCE(auth_results_dkim_domain, '') != '' OR COALESCE(auth_results_dkim_result, '') != '' ORDER BY id",
+			"INSERT INTO report_record_spf_models (created_at, report_record_id, domain, scope, result, human_result) " +
+				"SELECT created_at, id, COALESCE(auth_results_spf_domain, ''), COALESCE(auth_results_spf_scope, ''), " +
+				"COALESCE(auth_results_spf_result, ''), COALESCE(auth_results_spf_human_result, '') FROM report_record_models " +
+				"WHERE COALESCE(auth_results_spf_domain, '') != '' OR COALESCE(auth_results_spf_result, '') != '' ORDER BY id",
+		}
+		for _, column := range legacyAuthResultColumns {
+			statements = append(statements, "ALTER TABLE report_record_models DROP COLUMN "+column)
+		}
+
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateReportIdentity moves databases where reports were identified by the
+// report ID alone to the surrogate ID, keeping all the stored reports.
+// The raw payload of those reports is not available, so their content hash
+// stays empty until the same report is received again.
+func (s *SqliteStorage) migrateReportIdentity() error {
+	migrator := s.db.Migrator()
+	if !migrator.HasTable(&ReportModel{}) || migrator.HasColumn(&ReportModel{}, "id") {
+		return nil
+	}
+
+	log.Info("Migrating reports to the reporter and report ID identity")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		migrator := tx.Migrator()
+
+		legacyColumns, err := migrator.ColumnTypes("report_models")
+		if err != nil {
+			return err
+		}
+
+		columns := make([]string, len(legacyColumns))
+		for idx, column := range legacyColumns {
+			columns[idx] = "`" + column.Name() + "`"
+		}
+
+		// The report errors were added later, the first databases have no table for them
+		errorsTable := migrator.HasTable("report_error_models")
+
+		tables := []string{"report_models"}
+		if errorsTable {
+			tables = append(tables, "report_error_models")
+		}
+
+		for _, table := range tables {
+			if err := migrator.RenameTable(table, table+"_legacy"); err != nil {
+				return err
+			}
+		}
+
+		if err := migrator.AutoMigrate(&ReportModel{}, &ReportErrorModel{}, &ReportRecordModel{}); err != nil {
+			return err
+		}
+
+		statements := []string{
+			fmt.Sprintf("INSERT INTO report_models (%[1]s) SELECT %[1]s FROM report_models_legacy", strings.Join(columns, ", ")),
+		}
+		if errorsTable {
+			statements = append(statements,
+				"INSERT INTO report_error_models (created_at, report_model_id, message) "+
+					"SELECT e.created_at, r.id, e.message FROM report_error_models_legacy e "+
+					"JOIN report_models r ON r.report_id = e.report_id ORDER BY e.id",
+				"DROP TABLE report_error_models_legacy",
+			)
+		}
+		statements = append(statements,
+			"UPDATE report_record_models SET report_model_id = "+
+				"(SELECT id FROM report_models WHERE report_models.report_id = report_record_models.report_id)",
+			"ALTER TABLE report_record_models DROP COLUMN report_id",
+			"DROP TABLE report_models_legacy",
+		)
+
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// normalizedColumn is a column holding a domain or an IP address, and the
+// column keeping the value as sent when it was not in its canonical form
+type normalizedColumn struct {
+	name      string
+	original  string
+	normalize func(string) string
+}
+
+// normalizedTables are the columns normalized by parsers.Report.Normalize, by table
+var normalizedTables = []struct {
+	table   string
+	columns []normalizedColumn
+}{
+	{"report_models", []normalizedColumn{
+		{"policy_published_domain", "policy_published_original_domain", parsers.NormalizeDomain},
+	}},
+	{"report_record_models", []normalizedColumn{
+		{"source_ip", "", parsers.NormalizeIP},
+		{"identifiers_header_from", "identifiers_original_header_from", parsers.NormalizeDomain},
+		{"identifiers_envelope_from", "identifiers_original_envelope_from", parsers.NormalizeDomain},
+		{"identifiers_envelope_to", "identifiers_original_envelope_to", parsers.NormalizeDomain},
+	}},
+	{"report_record_dkim_models", []normalizedColumn{
+		{"domain", "original_domain", parsers.NormalizeDomain},
+	}},
+	{"report_record_spf_models", []normalizedColumn{
+		{"domain", "original_domain", parsers.NormalizeDomain},
+	}},
+}
+
+// needsNormalization tells if the database has reports stored before domains
+// and IP addresses were normalized, which have no column for the original domain
+func (s *SqliteStorage) needsNormalization() bool {
+	migrator := s.db.Migrator()
+	return migrator.HasTable(&ReportModel{}) && !migrator.HasColumn(&ReportModel{}, "policy_published_original_domain")
+}
+
+// migrateNormalization converts the domains and IP addresses of the reports
+// stored before they were normalized to their canonical form, keeping the
+// values as sent like for the reports received since
+func (s *SqliteStorage) migrateNormalization() error {
+	log.Info("Migrating the domains and IP addresses of stored reports to their canonical form")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, normalized := range normalizedTables {
+			if err := normalizeTable(tx, normalized.table, normalized.columns); err != nil {
+				return fmt.Errorf("failed to normalize %s: %w", normalized.table, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// normalizeTable normalizes the columns of every row of a table
+func normalizeTable(tx *gorm.DB, table string, columns []normalizedColumn) error {
+	names := []string{"id"}
+	for _, column := range columns {
+		names = append(names, column.name)
+	}
+
+	rows, err := tx.Table(table).Select(names).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// The rows are updated once read, the connection of the transaction is busy until then
+	type update struct {
+		id     uint
+		values map[string]interface{}
+	}
+	updates := []update{}
+
+	for rows.Next() {
+		var id uint
+		values := make([]sql.NullString, len(columns))
+		dest := []interface{}{&id}
+		for idx := range values {
+			dest = append(dest, &values[idx])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		changed := map[string]interface{}{}
+		for idx, column := range columns {
+			value := values[idx].String
+			normalized := column.normalize(value)
+			if normalized == value {
+				continue
+			}
+
+			changed[column.name] = normalized
+			if column.original != "" {
+				changed[column.original] = value
+			}
+		}
+
+		if len(changed) > 0 {
+			updates = append(updates, update{id: id, values: changed})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, update := range updates {
+		if err := tx.Table(table).Where("id = ?", update.id).Updates(update.values).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
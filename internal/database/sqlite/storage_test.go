@@ -0,0 +1,171 @@
+package database_sqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// legacySchema is the schema of the first release, where reports were
+// identified by the report ID alone and records had a single DKIM and SPF result
+var legacySchema = []string{
+	"CREATE TABLE `report_models` (`report_id` text,`created_at` integer,`version` text,`report_metadata_org_name` text,`report_metadata_email` text,`report_metadata_extra_contact_info` text,`report_date_range_begin` datetime,`report_date_range_end` datetime,`policy_published_domain` text,`policy_published_alignment_mode_dkim` text,`policy_published_alignment_mode_spf` text,`policy_published_policy` text,`policy_published_subdomain_policy` text,`policy_published_percentage` integer,`policy_published_failure_reporting_options` text,PRIMARY KEY (`report_id`))",
+	"CREATE TABLE `report_record_models` (`id` integer,`created_at` integer,`report_id` text,`source_ip` text,`count` integer,`policy_evaluated_disposition` text,`policy_evaluated_dkim` text,`policy_evaluated_spf` text,`identifiers_header_from` text,`identifiers_envelope_from` text,`identifiers_envelope_to` text,`auth_results_dkim_domain` text,`auth_results_dkim_result` text,`auth_results_dkim_selector` text,`auth_results_dkim_human_result` text,`auth_results_spf_domain` text,`auth_results_spf_result` text,`auth_results_spf_scope` text,`auth_results_spf_human_result` text,PRIMARY KEY (`id`))",
+	"CREATE TABLE `address_models` (`ip` text,`hostname` text,`created_at` integer,`update_at` integer,PRIMARY KEY (`ip`))",
+	"INSERT INTO report_models (report_id, report_metadata_org_name, report_date_range_begin, report_date_range_end, policy_published_domain) VALUES ('valid5reportid', 'google.com', '2023-10-20 00:00:00+00:00', '2023-10-20 23:59:59+00:00', 'example.com')",
+	"INSERT INTO report_record_models (id, report_id, source_ip, count, policy_evaluated_dkim, policy_evaluated_spf, identifiers_header_from, " +
+		"auth_results_dkim_domain, auth_results_dkim_result, auth_results_dkim_selector, auth_results_spf_domain, auth_results_spf_result, auth_results_spf_scope) " +
+		"VALUES (7, 'valid5reportid', '192.0.2.1', 3, 'pass', 'fail', 'example.com', 'example.com', 'pass', 'google', 'example.com', 'fail', 'mfrom')",
+}
+
+func TestMigrateReportIdentity(t *testing.T) {
+	store, err := NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	for _, statement := range legacySchema {
+		if err := store.db.Exec(statement).Error; err != nil {
+			t.Fatalf("failed to create legacy schema: %s", err)
+		}
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	report, err := store.FindReportByReportID("google.com", "valid5reportid")
+	if err != nil {
+		t.Fatalf("failed to find migrated report: %s", err)
+	}
+
+	if len(report.Records) != 1 || report.Records[0].Row.Count != 3 {
+		t.Fatalf("expected the record to be migrated, got %+v", report.Records)
+	}
+
+	// The first resend records the content hash of the migrated report
+	resent := readTestReport(t, "valid5.xml", func(data string) string { return data })
+	if err := store.CreateReport(resent); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error for a resend, got %v", err)
+	}
+
+	if _, err := store.FindReportByHash(resent.ContentHash); err != nil {
+		t.Errorf("expected the content hash to be recorded: %s", err)
+	}
+
+	// Migrating again is a no-op
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage again: %s", err)
+	}
+}
+
+func TestMigrateNormalization(t *testing.T) {
+	store, err := NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	// Reports stored before normalization have the values as sent
+	statements := append(legacySchema,
+		"UPDATE report_models SET policy_published_domain = 'Example.COM.'",
+		"UPDATE report_record_models SET source_ip = '::FFFF:192.0.2.1', identifiers_header_from = 'bücher.example', identifiers_envelope_from = 'example.com'",
+		"UPDATE report_record_models SET auth_results_spf_domain = 'MAIL.example.com'",
+	)
+	for _, statement := range statements {
+		if err := store.db.Exec(statement).Error; err != nil {
+			t.Fatalf("failed to create legacy schema: %s", err)
+		}
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	report, err := store.FindReportByReportID("google.com", "valid5reportid")
+	if err != nil {
+		t.Fatalf("failed to find migrated report: %s", err)
+	}
+
+	if report.PolicyPublished.Domain != "example.com" || report.PolicyPublished.OriginalDomain != "Example.COM." {
+		t.Errorf("expected the policy domain example.com sent as Example.COM., got %+v", report.PolicyPublished)
+	}
+
+	record := report.Records[0]
+	if record.Row.SourceIP != "192.0.2.1" {
+		t.Errorf("expected the source IP 192.0.2.1, got %s", record.Row.SourceIP)
+	}
+
+	identifiers := record.Identifiers
+	if identifiers.HeaderFrom != "xn--bcher-kva.example" || identifiers.OriginalHeaderFrom != "bücher.example" {
+		t.Errorf("expected the header from in its A-label form, got %+v", identifiers)
+	}
+
+	// Values already in their canonical form keep no original
+	if identifiers.EnvelopeFrom != "example.com" || identifiers.OriginalEnvelopeFrom != "" {
+		t.Errorf("expected the envelope from to be unchanged, got %+v", identifiers)
+	}
+
+	// The DKIM and SPF results moved out of the record are normalized too
+	if spf := record.AuthResults.SPF[0]; spf.Domain != "mail.example.com" || spf.OriginalDomain != "MAIL.example.com" {
+		t.Errorf("expected the SPF domain mail.example.com sent as MAIL.example.com, got %+v", spf)
+	}
+
+	page, err := store.QueryReports(database.ReportQuery{Domain: "example.com"})
+	if err != nil {
+		t.Fatalf("failed to query reports: %s", err)
+	}
+	if len(page.Reports) != 1 {
+		t.Errorf("expected the migrated report to match the domain filter, got %d reports", len(page.Reports))
+	}
+}
+
+func TestMigrateAuthResults(t *testing.T) {
+	store, err := NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	// A record stored without auth results has empty columns
+	statements := append(legacySchema,
+		"INSERT INTO report_record_models (id, report_id, source_ip, count, auth_results_dkim_domain, auth_results_spf_domain) VALUES (8, 'valid5reportid', '192.0.2.2', 1, '', '')",
+	)
+	for _, statement := range statements {
+		if err := store.db.Exec(statement).Error; err != nil {
+			t.Fatalf("failed to create legacy schema: %s", err)
+		}
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	report, err := store.FindReportByReportID("google.com", "valid5reportid")
+	if err != nil {
+		t.Fatalf("failed to find migrated report: %s", err)
+	}
+	if len(report.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(report.Records))
+	}
+
+	expected := parsers.AuthResult{
+		DKIM: []parsers.DKIMAuthResult{{Domain: "example.com", Selector: "google", Result: "pass"}},
+		SPF:  []parsers.SPFAuthResult{{Domain: "example.com", Scope: "mfrom", Result: "fail"}},
+	}
+	if !reflect.DeepEqual(report.Records[0].AuthResults, expected) {
+		t.Errorf("expected the auth results to be migrated\nwant: %+v\ngot:  %+v", expected, report.Records[0].AuthResults)
+	}
+
+	if auth := report.Records[1].AuthResults; len(auth.DKIM) != 0 || len(auth.SPF) != 0 {
+		t.Errorf("expected no auth results for the record without any, got %+v", auth)
+	}
+
+	for _, column := range legacyAuthResultColumns {
+		if store.db.Migrator().HasColumn(&ReportRecordModel{}, column) {
+			t.Errorf("expected the legacy column %s to be dropped", column)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package database_sqlite
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"gorm.io/gorm"
+)
+
+// TLSReportModel holds an SMTP TLS report. Resends share the content hash.
+type TLSReportModel struct {
+	ID               uint   `gorm:"primaryKey"`
+	CreatedAt        int64  `gorm:"autoCreateTime"`
+	ContentHash      string `gorm:"uniqueIndex"`
+	OrganizationName string `gorm:"index"`
+	ContactInfo      string
+	ReportID         string `gorm:"index"`
+	StartDatetime    time.Time
+	EndDatetime      time.Time
+	Policies         []TLSPolicyModel `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// TLSPolicyModel holds the session counts of a single policy of a TLS report
+type TLSPolicyModel struct {
+	ID               uint `gorm:"primaryKey"`
+	TLSReportModelID uint `gorm:"index"`
+	PolicyType       string
+	// PolicyString and MXHost are stored one item per line
+	PolicyString                string
+	PolicyDomain                string `gorm:"index"`
+	OriginalPolicyDomain        string
+	MXHost                      string
+	TotalSuccessfulSessionCount int64
+	TotalFailureSessionCount    int64
+	FailureDetails              []TLSFailureDetailModel `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// TLSFailureDetailModel holds the failed sessions of a policy with the same result
+type TLSFailureDetailModel struct {
+	ID                    uint `gorm:"primaryKey"`
+	TLSPolicyModelID      uint `gorm:"index"`
+	ResultType            string
+	SendingMTAIP          string
+	ReceivingMXHostname   string
+	ReceivingMXHelo       string
+	ReceivingIP           string
+	FailedSessionCount    int64
+	AdditionalInformation string
+	FailureReasonCode     string
+}
+
+// CreateTLSReport stores a TLS report with its policies and failure details.
+// It returns database.ErrDuplicateReport for an exact resend of a stored report.
+func (s *SqliteStorage) CreateTLSReport(report *parsers.TLSReport) error {
+	s.mutexWrite.Lock()
+	defer s.mutexWrite.Unlock()
+
+	if err := s.db.Create(TLSReportToModel(report)).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return database.ErrDuplicateReport
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// FindTLSReportByHash returns the TLS report with the given content hash
+func (s *SqliteStorage) FindTLSReportByHash(hash string) (*parsers.TLSReport, error) {
+	model := &TLSReportModel{}
+
+	if err := s.preloadTLSReports().Where("content_hash = ?", hash).First(model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, database.ErrReportNotFound
+		}
+		return nil, err
+	}
+
+	return ModelToTLSReport(model), nil
+}
+
+// FindTLSReports returns the TLS reports with a policy for a domain, or all of
+// them if it is empty, in the order of their date range
+func (s *SqliteStorage) FindTLSReports(domain string) ([]*parsers.TLSReport, error) {
+	models := []*TLSReportModel{}
+
+	tx := s.preloadTLSReports().Order("start_datetime, id")
+	if domain != "" {
+		tx = tx.Where("id IN (?)", s.db.Model(&TLSPolicyModel{}).
+			Select("tls_report_model_id").
+			Where("policy_domain = ?", parsers.NormalizeDomain(domain)))
+	}
+
+	if err := tx.Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	reports := make([]*parsers.TLSReport, len(models))
+	for idx, model := range models {
+		// Convert the TLSReportModel to a parsers.TLSReport
+		reports[idx] = ModelToTLSReport(model)
+	}
+
+	return reports, nil
+}
+
+func (s *SqliteStorage) preloadTLSReports() *gorm.DB {
+	return s.db.
+		Preload("Policies", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Policies.FailureDetails", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") })
+}
+
+// Converts a parsers.TLSReport to a TLSReportModel
+func TLSReportToModel(r *parsers.TLSReport) *TLSReportModel {
+	policies := make([]TLSPolicyModel, len(r.Policies))
+	for idx, policy := range r.Policies {
+		details := make([]TLSFailureDetailModel, len(policy.FailureDetails))
+		for idx, detail := range policy.FailureDetails {
+			details[idx] = TLSFailureDetailModel{
+				ResultType:            detail.ResultType,
+				SendingMTAIP:          detail.SendingMTAIP,
+				ReceivingMXHostname:   detail.ReceivingMXHostname,
+				ReceivingMXHelo:       detail.ReceivingMXHelo,
+				ReceivingIP:           detail.ReceivingIP,
+				FailedSessionCount:    detail.FailedSessionCount,
+				AdditionalInformation: detail.AdditionalInformation,
+				FailureReasonCode:     detail.FailureReasonCode,
+			}
+		}
+
+		policies[idx] = TLSPolicyModel{
+			PolicyType:                  policy.Policy.PolicyType,
+			PolicyString:                strings.Join(policy.Policy.PolicyString, "\n"),
+			PolicyDomain:                policy.Policy.PolicyDomain,
+			OriginalPolicyDomain:        policy.Policy.OriginalPolicyDomain,
+			MXHost:                      strings.Join(policy.Policy.MXHost, "\n"),
+			TotalSuccessfulSessionCount: policy.Summary.TotalSuccessfulSessionCount,
+			TotalFailureSessionCount:    policy.Summary.TotalFailureSessionCount,
+			FailureDetails:              details,
+		}
+	}
+
+	return &TLSReportModel{
+		ContentHash:      r.ContentHash,
+		OrganizationName: r.OrganizationName,
+		ContactInfo:      r.ContactInfo,
+		ReportID:         r.ReportID,
+		StartDatetime:    r.DateRange.StartDatetime.UTC(),
+		EndDatetime:      r.DateRange.EndDatetime.UTC(),
+		Policies:         policies,
+	}
+}
+
+// Converts a TLSReportModel to a parsers.TLSReport
+func ModelToTLSReport(r *TLSReportModel) *parsers.TLSReport {
+	policies := make([]parsers.TLSPolicy, len(r.Policies))
+	for idx, policy := range r.Policies {
+		var details []parsers.TLSFailureDetail
+		for _, detail := range policy.FailureDetails {
+			details = append(details, parsers.TLSFailureDetail{
+				ResultType:            detail.ResultType,
+				SendingMTAIP:          detail.SendingMTAIP,
+				ReceivingMXHostname:   detail.ReceivingMXHostname,
+				ReceivingMXHelo:       detail.ReceivingMXHelo,
+				ReceivingIP:           detail.ReceivingIP,
+				FailedSessionCount:    detail.FailedSessionCount,
+				AdditionalInformation: detail.AdditionalInformation,
+				FailureReasonCode:     detail.FailureReasonCode,
+			})
+		}
+
+		policies[idx] = parsers.TLSPolicy{
+			Policy: parsers.TLSPolicyDetails{
+				PolicyType:           policy.PolicyType,
+				PolicyString:         splitLines(policy.PolicyString),
+				PolicyDomain:         policy.PolicyDomain,
+				OriginalPolicyDomain: policy.OriginalPolicyDomain,
+				MXHost:               splitLines(policy.MXHost),
+			},
+			Summary: parsers.TLSSummary{
+				TotalSuccessfulSessionCount: policy.TotalSuccessfulSessionCount,
+				TotalFailureSessionCount:    policy.TotalFailureSessionCount,
+			},
+			FailureDetails: details,
+		}
+	}
+
+	return &parsers.TLSReport{
+		OrganizationName: r.OrganizationName,
+		DateRange: parsers.TLSDateRange{
+			StartDatetime: r.StartDatetime.UTC(),
+			EndDatetime:   r.EndDatetime.UTC(),
+		},
+		ContactInfo: r.ContactInfo,
+		ReportID:    r.ReportID,
+		Policies:    policies,
+		ContentHash: r.ContentHash,
+	}
+}
+
+// splitLines reverses strings.Join(lines, "\n"), an empty string has no lines
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, "\n")
+}
@@ -0,0 +1,76 @@
+package database_sqlite
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+func TestCreateTLSReport(t *testing.T) {
+	store := newTestStorage(t)
+
+	report := &parsers.TLSReport{
+		OrganizationName: "Company-X",
+		DateRange: parsers.TLSDateRange{
+			StartDatetime: time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC),
+			EndDatetime:   time.Date(2016, 4, 1, 23, 59, 59, 0, time.UTC),
+		},
+		ContactInfo: "sts-reporting@company-x.example",
+		ReportID:    "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+		Policies: []parsers.TLSPolicy{
+			{
+				Policy: parsers.TLSPolicyDetails{
+					PolicyType:           parsers.TLSPolicyTypeSTS,
+					PolicyString:         []string{"version: STSv1", "mode: testing"},
+					PolicyDomain:         "company-y.example",
+					OriginalPolicyDomain: "Company-Y.example.",
+					MXHost:               []string{"*.mail.company-y.example"},
+				},
+				Summary: parsers.TLSSummary{TotalSuccessfulSessionCount: 5326, TotalFailureSessionCount: 303},
+				FailureDetails: []parsers.TLSFailureDetail{
+					{ResultType: "certificate-expired", SendingMTAIP: "2001:db8::1", FailedSessionCount: 100},
+					{ResultType: "validation-failure", ReceivingIP: "203.0.113.58", FailedSessionCount: 203, FailureReasonCode: "X509_V_ERR"},
+				},
+			},
+			{
+				Policy:  parsers.TLSPolicyDetails{PolicyType: parsers.TLSPolicyTypeNoPolicy, PolicyDomain: "company-z.example"},
+				Summary: parsers.TLSSummary{TotalSuccessfulSessionCount: 10},
+			},
+		},
+		ContentHash: "hash",
+	}
+
+	if err := store.CreateTLSReport(report); err != nil {
+		t.Fatalf("failed to store TLS report: %s", err)
+	}
+
+	if err := store.CreateTLSReport(report); !errors.Is(err, database.ErrDuplicateReport) {
+		t.Errorf("expected a duplicate report error, got %v", err)
+	}
+
+	stored, err := store.FindTLSReportByHash("hash")
+	if err != nil {
+		t.Fatalf("failed to find TLS report: %s", err)
+	}
+	if !reflect.DeepEqual(stored, report) {
+		t.Errorf("stored TLS report does not match\nwant: %+v\ngot:  %+v", report, stored)
+	}
+
+	if _, err := store.FindTLSReportByHash("missing"); !errors.Is(err, database.ErrReportNotFound) {
+		t.Errorf("expected a report not found error, got %v", err)
+	}
+
+	for domain, expected := range map[string]int{"": 1, "COMPANY-Z.example.": 1, "company-x.example": 0} {
+		reports, err := store.FindTLSReports(domain)
+		if err != nil {
+			t.Fatalf("failed to find TLS reports: %s", err)
+		}
+		if len(reports) != expected {
+			t.Errorf("expected %d TLS reports for %q, got %d", expected, domain, len(reports))
+		}
+	}
+}
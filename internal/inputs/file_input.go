@@ -1,6 +1,8 @@
 package inputs
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,15 +11,25 @@ import (
 
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
-	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
 )
 
+// DefaultFileWorkers is the number of files a FileInput processes concurrently
+const DefaultFileWorkers = 4
+
 type FileInput struct {
 	ReportsPath          string
 	FailedReportsPath    string
 	ProcessedReportsPath string
-	store                database.Storage
-	mutexProcess         sync.Mutex
+	// WatchMode selects how Watch notices new files, defaults to WatchPoll
+	WatchMode WatchMode
+	// Workers is the number of files processed concurrently, defaults to DefaultFileWorkers
+	Workers int
+	Options
+	store database.Storage
+	// inFlight are the files being processed, so a file listed by overlapping
+	// scans, or by a scan and the watcher, is only processed once
+	inFlight      map[string]bool
+	mutexInFlight sync.Mutex
 }
 
 // NewFileInput creates a new FileInput
@@ -39,69 +51,149 @@ func NewFileInput(reportsPath string, store database.Storage) (*FileInput, error
 		ReportsPath:          path,
 		FailedReportsPath:    path + "/failed",
 		ProcessedReportsPath: path + "/processed",
+		WatchMode:            WatchPoll,
+		Workers:              DefaultFileWorkers,
+		Options:              DefaultOptions,
 		store:                store,
-		mutexProcess:         sync.Mutex{},
+		inFlight:             map[string]bool{},
 	}, nil
 }
 
-// Watcher watches the reports directory for new files
-// and processes them on a given interval
-func (f *FileInput) Watch(interval time.Duration) {
-	for {
-		f.ProcessAll()
-		time.Sleep(interval)
+// Watch watches the reports directory for new files. In WatchPoll mode they
+// are processed on a given interval, in WatchNotify mode as soon as they are
+// written, with the interval only used if the directory cannot be watched.
+func (f *FileInput) Watch(ctx context.Context, interval time.Duration) {
+	if f.WatchMode == WatchNotify {
+		f.watchNotify(ctx, interval)
+		return
 	}
+
+	poll(ctx, interval, f.ProcessAll)
 }
 
-// StoreReport takes a byte slice of a report and stores it in the database
+// StoreReport takes a byte slice of a report attachment (xml, gzip or zip),
+// and stores every report it contains in the database
 func (f *FileInput) StoreReport(data []byte) error {
-	report, err := parsers.NewReport(data)
+	return storeReport(f.store, f.Options, data)
+}
+
+// ProcessAll processes all the reports in the reports directory, with a pool
+// of Workers. It returns once every file is processed, or once the context is
+// done and the files being processed are finished.
+func (f *FileInput) ProcessAll(ctx context.Context) {
+	files := make(chan string)
+	workers := f.startWorkers(files)
+
+	for _, file := range f.reportFiles() {
+		// The files left are processed on the next start
+		select {
+		case files <- file:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// The workers finish the files they hold before ProcessAll returns
+	close(files)
+	workers.Wait()
+}
+
+// reportFiles returns the files of the reports directory to process. Reports
+// are recognised by their content, not their extension, so every regular file
+// in the reports directory is returned.
+func (f *FileInput) reportFiles() []string {
+	entries, err := os.ReadDir(f.ReportsPath)
 	if err != nil {
-		return err
+		log.Errorf("Failed to read reports path %s: %s", f.ReportsPath, err)
 	}
 
-	log.Infof("Saving report %s", report.ReportMetadata.ReportID)
+	files := []string{}
+	for _, entry := range entries {
+		if isReportFile(entry.Name(), entry.Type()) {
+			files = append(files, f.ReportsPath+"/"+entry.Name())
+		}
+	}
 
-	if err := f.store.CreateReport(report); err != nil {
-		log.Errorf("Failed to save report %s: %s", report.ReportMetadata.ReportID, err)
-		return err
+	return files
+}
+
+// startWorkers starts Workers goroutines processing the files sent on the
+// channel, until it is closed. The WaitGroup is done once they all returned.
+func (f *FileInput) startWorkers(files <-chan string) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < max(f.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				f.processFile(file)
+			}
+		}()
 	}
 
-	log.Infof("Saved report %s", report.ReportMetadata.ReportID)
-	return nil
+	return wg
 }
 
-// ProcessAll processes all the reports in the reports directory
-func (f *FileInput) ProcessAll() {
-	// We lock the process mutex to avoid multiple calls to ProcessAll
-	// Multiple calls to ProcessAll can happen if the watcher interval is
-	// less than the time it takes to process all the reports
-	// Or the initial call to ProcessAll that runs on startup
-	// is still running when the watcher interval is reached
-	f.mutexProcess.Lock()
-	defer f.mutexProcess.Unlock()
-
-	files, err := filepath.Glob(f.ReportsPath + "/*.xml")
-	if err != nil {
-		log.Errorf("Failed to glob reports path %s: %s", f.ReportsPath, err)
+// processFile processes a file of the reports directory, unless another worker
+// is processing it, or it was processed since it was listed
+func (f *FileInput) processFile(file string) {
+	if !f.claim(file) {
+		return
 	}
+	defer f.release(file)
 
-	for _, file := range files {
-		if err := f.Process(file); err != nil {
-			log.Errorf("Failed to process file %s: %s", file, err)
-		}
+	info, err := os.Lstat(file)
+	if err != nil || !isReportFile(info.Name(), info.Mode()) {
+		return
 	}
+
+	if err := f.Process(file); err != nil {
+		log.Errorf("Failed to process file %s: %s", file, err)
+	}
+}
+
+// claim marks a file as being processed, it returns false if it already is
+func (f *FileInput) claim(file string) bool {
+	f.mutexInFlight.Lock()
+	defer f.mutexInFlight.Unlock()
+
+	if f.inFlight[file] {
+		return false
+	}
+
+	f.inFlight[file] = true
+	return true
+}
+
+func (f *FileInput) release(file string) {
+	f.mutexInFlight.Lock()
+	defer f.mutexInFlight.Unlock()
+
+	delete(f.inFlight, file)
 }
 
 // Process processes a single report file
 func (f *FileInput) Process(file string) error {
-	data, err := os.ReadFile(file)
+	reader, err := os.Open(file)
 	if err != nil {
 		os.Rename(file, f.FailedReportsPath+"/"+filepath.Base(file))
 		log.Errorf("Failed to read file %s: %s", file, err)
 		return err
 	}
-	if err := f.StoreReport(data); err != nil {
+
+	// Reports are streamed from the file, as large reports can have tens of thousands of records
+	err = storeFile(f.store, f.Options, reader)
+	reader.Close()
+
+	// The file stays in the reports directory and is retried by the next scan
+	if errors.Is(err, ErrStorage) {
+		log.Errorf("Failed to store file %s, leaving it for retry: %s", file, err)
+		return err
+	}
+
+	if err != nil {
 		log.Errorf("Failed to store file %s: %s", file, err)
 		os.Rename(file, f.FailedReportsPath+"/"+filepath.Base(file))
 		return err
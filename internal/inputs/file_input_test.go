@@ -0,0 +1,427 @@
+package inputs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+func zipData(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, data := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %s", err)
+		}
+		if _, err := file.Write(data); err != nil {
+			t.Fatalf("failed to zip: %s", err)
+		}
+	}
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+func TestFileInputProcessAll(t *testing.T) {
+	store := newTestStorage(t)
+	dir := t.TempDir()
+
+	input, err := NewFileInput(dir, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	files := map[string][]byte{
+		"valid1.xml":    readTestData(t, "valid1.xml"),
+		"valid5.xml.gz": gzipData(t, readTestData(t, "valid5.xml")),
+		"reports.zip": zipData(t, map[string][]byte{
+			"extensions.xml": readTestData(t, "extensions.xml"),
+			"__MACOSX/._x":   {0, 5, 22, 7},
+		}),
+		"malformed2.xml": readTestData(t, "malformed2.xml"),
+		"utf-16.xml":     readTestData(t, "utf-16.xml"),
+		"garbage.xml.gz": gzipData(t, readTestData(t, "garbage.xml")),
+		"tlsrpt.json.gz": gzipData(t, readTestData(t, "tlsrpt.json")),
+		"failure.eml":    readTestData(t, "failure.eml"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("failed to write report: %s", err)
+		}
+	}
+
+	input.ProcessAll(context.Background())
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 5 {
+		t.Fatalf("expected 5 reports, got %d", len(reports))
+	}
+
+	// A streamed report is stored as if it was parsed in memory
+	expected, err := store.FindReportByReportID("google.com", "valid5reportid")
+	if err != nil {
+		t.Fatalf("expected report to be stored: %s", err)
+	}
+	if len(expected.Records) != 2 || expected.ContentHash == "" {
+		t.Errorf("expected 2 records and a content hash, got %d and %q", len(expected.Records), expected.ContentHash)
+	}
+
+	tlsReports, err := store.FindTLSReports("")
+	if err != nil {
+		t.Fatalf("failed to find TLS reports: %s", err)
+	}
+	if len(tlsReports) != 1 {
+		t.Fatalf("expected 1 TLS report, got %d", len(tlsReports))
+	}
+
+	failureReports, err := store.FindFailureReports("example.com")
+	if err != nil {
+		t.Fatalf("failed to find failure reports: %s", err)
+	}
+	if len(failureReports) != 1 {
+		t.Fatalf("expected 1 failure report, got %d", len(failureReports))
+	}
+
+	moved := []string{
+		filepath.Join(dir, "processed", "valid1.xml"),
+		filepath.Join(dir, "processed", "valid5.xml.gz"),
+		filepath.Join(dir, "processed", "reports.zip"),
+		filepath.Join(dir, "processed", "utf-16.xml"),
+		filepath.Join(dir, "processed", "garbage.xml.gz"),
+		filepath.Join(dir, "processed", "tlsrpt.json.gz"),
+		filepath.Join(dir, "processed", "failure.eml"),
+		filepath.Join(dir, "failed", "malformed2.xml"),
+	}
+	for _, path := range moved {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected file at %s: %s", path, err)
+		}
+	}
+}
+
+func TestFileInputStorageFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	input, err := NewFileInput(dir, &failingStorage{newTestStorage(t)})
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	file := filepath.Join(dir, "valid5.xml")
+	if err := os.WriteFile(file, readTestData(t, "valid5.xml"), 0600); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+
+	// The file is retried by the next scan once the storage is available again
+	if err := input.Process(file); !errors.Is(err, ErrStorage) {
+		t.Errorf("expected a storage error, got %v", err)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the file to stay in the reports directory: %s", err)
+	}
+}
+
+func TestFileInputWatchNotify(t *testing.T) {
+	store := newTestStorage(t)
+	dir := t.TempDir()
+
+	// A report already in the directory is picked up by the scan on start
+	if err := os.WriteFile(filepath.Join(dir, "valid1.xml"), readTestData(t, "valid1.xml"), 0600); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+
+	input, err := NewFileInput(dir, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+	input.WatchMode = WatchNotify
+
+	delay := fileSettleDelay
+	fileSettleDelay = 200 * time.Millisecond
+	t.Cleanup(func() { fileSettleDelay = delay })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		input.Watch(ctx, time.Hour)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	waitForFile(t, filepath.Join(dir, "processed", "valid1.xml"))
+
+	// A report written in two parts is only processed once it is complete
+	file, err := os.Create(filepath.Join(dir, "valid2.xml"))
+	if err != nil {
+		t.Fatalf("failed to create report: %s", err)
+	}
+	data := readTestData(t, "valid2.xml")
+	if _, err := file.Write(data[:len(data)/2]); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+	time.Sleep(fileSettleDelay / 2)
+	if _, err := file.Write(data[len(data)/2:]); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close report: %s", err)
+	}
+
+	waitForFile(t, filepath.Join(dir, "processed", "valid2.xml"))
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected 2 reports, got %d", len(reports))
+	}
+}
+
+// blockingStorage holds every report until release is closed
+type blockingStorage struct {
+	database.Storage
+	release chan struct{}
+}
+
+func (b *blockingStorage) CreateReportStream(stream database.ReportStream) error {
+	<-b.release
+	return b.Storage.CreateReportStream(stream)
+}
+
+func TestFileInputWatchRescan(t *testing.T) {
+	store := &blockingStorage{Storage: newTestStorage(t), release: make(chan struct{})}
+	dir := t.TempDir()
+
+	input, err := NewFileInput(dir, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	events := make(chan fsnotify.Event)
+	errs := make(chan error)
+
+	// The workers finish their files before watchEvents returns
+	release := sync.OnceFunc(func() { close(store.release) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		input.watchEvents(ctx, time.Hour, events, errs)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		release()
+		<-stopped
+	})
+
+	// A watcher error queues the files its dropped events were about
+	writeTestReports(t, dir, 3)
+	errs <- fsnotify.ErrEventOverflow
+
+	// The events keep being read while the rescanned files are stored
+	select {
+	case events <- fsnotify.Event{Name: filepath.Join(dir, "report3.xml"), Op: fsnotify.Create}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the events to be read during the rescan")
+	}
+
+	release()
+	for i := 0; i < 3; i++ {
+		waitForFile(t, filepath.Join(input.ProcessedReportsPath, fmt.Sprintf("report%d.xml", i)))
+	}
+}
+
+// waitForFile waits for a file to exist, failing the test after a few seconds
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+
+	t.Fatalf("expected file at %s", path)
+}
+
+// writeTestReports writes n copies of valid1.xml with distinct report IDs
+func writeTestReports(tb testing.TB, dir string, n int) {
+	tb.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "valid1.xml"))
+	if err != nil {
+		tb.Fatalf("failed to read valid1.xml: %s", err)
+	}
+
+	for i := 0; i < n; i++ {
+		report := bytes.Replace(data, []byte("valid1reportid"), []byte(fmt.Sprintf("report%d", i)), 1)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("report%d.xml", i)), report, 0600); err != nil {
+			tb.Fatalf("failed to write report: %s", err)
+		}
+	}
+}
+
+func TestFileInputProcessAllConcurrently(t *testing.T) {
+	store := newTestStorage(t)
+	dir := t.TempDir()
+
+	input, err := NewFileInput(dir, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+	input.Workers = 8
+
+	writeTestReports(t, dir, 50)
+
+	// Overlapping scans share the files instead of processing them twice
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input.ProcessAll(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 50 {
+		t.Errorf("expected 50 reports, got %d", len(reports))
+	}
+
+	for dir, expected := range map[string]int{input.ProcessedReportsPath: 50, input.FailedReportsPath: 0} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", dir, err)
+		}
+		if len(entries) != expected {
+			t.Errorf("expected %d files in %s, got %d", expected, dir, len(entries))
+		}
+	}
+}
+
+func BenchmarkFileInputProcessAll(b *testing.B) {
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				store := newTestStorage(b)
+				dir := b.TempDir()
+				input, err := NewFileInput(dir, store)
+				if err != nil {
+					b.Fatalf("failed to create input: %s", err)
+				}
+				input.Workers = workers
+				writeTestReports(b, dir, 200)
+				b.StartTimer()
+
+				input.ProcessAll(context.Background())
+			}
+		})
+	}
+}
+
+// cancelingStorage cancels a context once a number of reports are stored,
+// like a shutdown signal arriving while files are being processed
+type cancelingStorage struct {
+	database.Storage
+	cancel context.CancelFunc
+	after  int32
+	stored atomic.Int32
+}
+
+func (c *cancelingStorage) CreateReportStream(stream database.ReportStream) error {
+	err := c.Storage.CreateReportStream(stream)
+	if c.stored.Add(1) == c.after {
+		c.cancel()
+	}
+
+	return err
+}
+
+func TestFileInputWatchShutdown(t *testing.T) {
+	store := newTestStorage(t)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input, err := NewFileInput(dir, &cancelingStorage{Storage: store, cancel: cancel, after: 10})
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	writeTestReports(t, dir, 200)
+
+	stopped := make(chan struct{})
+	go func() {
+		input.Watch(ctx, time.Hour)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected Watch to return once stopped")
+	}
+
+	// Every file is either processed and stored, or left untouched for the next start
+	processedCount := 0
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("report%d.xml", i)
+		_, pendingErr := os.Stat(filepath.Join(dir, name))
+		_, processedErr := os.Stat(filepath.Join(input.ProcessedReportsPath, name))
+		_, storedErr := store.FindReportByReportID("Yahoo", fmt.Sprintf("report%d", i))
+
+		pending, processed, stored := pendingErr == nil, processedErr == nil, storedErr == nil
+		if pending == processed || processed != stored {
+			t.Errorf("%s is left inconsistent: pending %t, processed %t, stored %t", name, pending, processed, stored)
+		}
+		if processed {
+			processedCount++
+		}
+	}
+
+	// The files being processed when the context was canceled are finished, no new one is started
+	if processedCount < 10 || processedCount >= 200 {
+		t.Errorf("expected processing to stop after 10 files and the ones in flight, got %d processed", processedCount)
+	}
+
+	entries, err := os.ReadDir(input.FailedReportsPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", input.FailedReportsPath, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no failed files, got %d", len(entries))
+	}
+
+	if err := store.Close(); err != nil {
+		t.Errorf("failed to close storage: %s", err)
+	}
+}
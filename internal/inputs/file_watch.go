@@ -0,0 +1,177 @@
+package inputs
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// WatchMode selects how a FileInput notices new files
+type WatchMode string
+
+const (
+	// WatchPoll rescans the reports directory on every interval
+	WatchPoll WatchMode = "poll"
+	// WatchNotify processes files as soon as they are written, using inotify
+	// (or the platform equivalent), and falls back to polling if it is not available
+	WatchNotify WatchMode = "notify"
+)
+
+// fileSettleDelay is how long a file must go without writes before it is
+// processed, so files that are still being written are not read half way
+var fileSettleDelay = 2 * time.Second
+
+// watchNotify processes the files written or moved into the reports directory,
+// once they stop changing. It scans the whole directory first, for the files
+// that arrived while nothing was watching, and polls if the watcher fails.
+func (f *FileInput) watchNotify(ctx context.Context, interval time.Duration) {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(f.ReportsPath)
+	}
+	if err != nil {
+		log.Errorf("Failed to watch reports path %s, polling instead: %s", f.ReportsPath, err)
+		if watcher != nil {
+			watcher.Close()
+		}
+		poll(ctx, interval, f.ProcessAll)
+		return
+	}
+	defer watcher.Close()
+
+	// The watcher is started first, so files written during the scan are not missed
+	f.ProcessAll(ctx)
+
+	f.watchEvents(ctx, interval, watcher.Events, watcher.Errors)
+}
+
+// watchEvents processes the files of the watcher events with a pool of Workers,
+// until the context is done. It polls once the events channel is closed.
+func (f *FileInput) watchEvents(ctx context.Context, interval time.Duration, events <-chan fsnotify.Event, errs <-chan error) {
+	// Settled files are queued for the workers, so the events keep being read
+	files := make(chan string)
+	workers := f.startWorkers(files)
+	pending := []string{}
+
+	// Stopping lets the workers finish their files, the queued ones are
+	// processed by the scan on the next start
+	defer workers.Wait()
+	defer close(files)
+
+	// Every write to a file starts a new timer, it is processed once the timer
+	// of its last write fires. A timer that already fired when a write stopped
+	// it still sends, so its generation tells it apart from the current one.
+	timers := map[string]settleTimer{}
+	settled := make(chan settledFile)
+	generation := uint64(0)
+
+	for {
+		// Sending is only enabled while files are queued
+		var next chan string
+		var head string
+		if len(pending) > 0 {
+			next, head = files, pending[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, timer := range timers {
+				timer.Stop()
+			}
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				log.Errorf("Stopped watching reports path %s, polling instead", f.ReportsPath)
+				poll(ctx, interval, f.ProcessAll)
+				return
+			}
+
+			// Files renamed into the directory are reported as created
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			name := event.Name
+			if timer, ok := timers[name]; ok {
+				timer.Stop()
+			}
+
+			generation++
+			file := settledFile{name: name, generation: generation}
+			timers[name] = settleTimer{
+				Timer: time.AfterFunc(fileSettleDelay, func() {
+					select {
+					case settled <- file:
+					case <-ctx.Done():
+					}
+				}),
+				generation: generation,
+			}
+
+		case file := <-settled:
+			// The file was written to since this timer fired
+			if timer, ok := timers[file.name]; !ok || timer.generation != file.generation {
+				continue
+			}
+
+			delete(timers, file.name)
+			pending = append(pending, file.name)
+
+		case next <- head:
+			pending = pending[1:]
+
+		case err, ok := <-errs:
+			if !ok {
+				// The events channel is closed as well, and falls back to polling
+				errs = nil
+				continue
+			}
+			// Events were dropped, e.g. the queue overflowed, so the files of the
+			// directory are queued for the workers, while the events keep being read
+			log.Errorf("Failed to watch reports path %s, rescanning: %s", f.ReportsPath, err)
+			pending = f.rescan(pending, timers)
+		}
+	}
+}
+
+// rescan queues the files of the reports directory that are not queued yet.
+// Files still being written are left to their timers.
+func (f *FileInput) rescan(pending []string, timers map[string]settleTimer) []string {
+	queued := make(map[string]bool, len(pending))
+	for _, name := range pending {
+		queued[name] = true
+	}
+
+	for _, name := range f.reportFiles() {
+		if _, ok := timers[name]; ok || queued[name] {
+			continue
+		}
+		pending = append(pending, name)
+	}
+
+	return pending
+}
+
+// settleTimer is the timer of the last write to a file
+type settleTimer struct {
+	*time.Timer
+	generation uint64
+}
+
+// settledFile is sent by a settleTimer once it fires
+type settledFile struct {
+	name       string
+	generation uint64
+}
+
+// isReportFile reports whether a file of the reports directory should be processed.
+// The failed/processed directories and hidden files, which are usually files
+// still being written, are skipped.
+func isReportFile(name string, mode fs.FileMode) bool {
+	return mode.IsRegular() && !strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,248 @@
+package inputs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+// IMAPConfig holds the connection details of the rua mailbox
+type IMAPConfig struct {
+	// Address of the IMAP server, as host:port
+	Address  string
+	Username string
+	Password string
+	// TLS connects using implicit TLS (usually port 993)
+	TLS bool
+	// StartTLS upgrades a plain connection (usually port 143)
+	StartTLS bool
+	// TLSConfig is used for both TLS and StartTLS, it can be nil
+	TLSConfig *tls.Config
+	// Mailbox to read the reports from, defaults to INBOX
+	Mailbox string
+	// ProcessedMailbox and FailedMailbox are where messages are moved to
+	// after processing. If empty, messages are only flagged as seen.
+	ProcessedMailbox string
+	FailedMailbox    string
+}
+
+type IMAPInput struct {
+	Config IMAPConfig
+	Options
+	store        database.Storage
+	mutexProcess sync.Mutex
+}
+
+// NewIMAPInput creates a new IMAPInput
+func NewIMAPInput(config IMAPConfig, store database.Storage) (*IMAPInput, error) {
+	if config.Address == "" {
+		return nil, errors.New("imap address is required")
+	}
+
+	if config.TLS && config.StartTLS {
+		return nil, errors.New("imap tls and starttls are mutually exclusive")
+	}
+
+	if config.Mailbox == "" {
+		config.Mailbox = "INBOX"
+	}
+
+	return &IMAPInput{
+		Config:       config,
+		Options:      DefaultOptions,
+		store:        store,
+		mutexProcess: sync.Mutex{},
+	}, nil
+}
+
+// Watch checks the mailbox for unseen messages on a given interval
+func (i *IMAPInput) Watch(ctx context.Context, interval time.Duration) {
+	poll(ctx, interval, i.ProcessAll)
+}
+
+// StoreReport takes a byte slice of a report attachment (xml, gzip or zip),
+// and stores every report it contains in the database
+func (i *IMAPInput) StoreReport(data []byte) error {
+	return storeReport(i.store, i.Options, data)
+}
+
+// ProcessAll processes all the unseen messages in the mailbox
+func (i *IMAPInput) ProcessAll(ctx context.Context) {
+	// Avoid overlapping runs, e.g. ProcessAll called while Watch runs on a slow mailbox
+	i.mutexProcess.Lock()
+	defer i.mutexProcess.Unlock()
+
+	c, err := i.connect()
+	if err != nil {
+		log.Errorf("Failed to connect to imap server %s: %s", i.Config.Address, err)
+		return
+	}
+	defer c.Logout()
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		log.Errorf("Failed to search mailbox %s: %s", i.Config.Mailbox, err)
+		return
+	}
+
+	for _, uid := range uids {
+		// The messages left unseen are processed on the next start
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := i.processMessage(c, uid); err != nil {
+			log.Errorf("Failed to process message %d: %s", uid, err)
+		}
+	}
+}
+
+// Process processes a single message, identified by its UID in the mailbox
+func (i *IMAPInput) Process(uid string) error {
+	id, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid message uid %s: %w", uid, err)
+	}
+
+	c, err := i.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	return i.processMessage(c, uint32(id))
+}
+
+// connect dials the server, logs in and selects the mailbox,
+// creating the processed and failed mailboxes if missing
+func (i *IMAPInput) connect() (*client.Client, error) {
+	var c *client.Client
+	var err error
+	if i.Config.TLS {
+		c, err = client.DialTLS(i.Config.Address, i.Config.TLSConfig)
+	} else {
+		c, err = client.Dial(i.Config.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i.Config.StartTLS {
+		if err := c.StartTLS(i.Config.TLSConfig); err != nil {
+			c.Logout()
+			return nil, err
+		}
+	}
+
+	if err := c.Login(i.Config.Username, i.Config.Password); err != nil {
+		c.Logout()
+		return nil, err
+	}
+
+	for _, mailbox := range []string{i.Config.ProcessedMailbox, i.Config.FailedMailbox} {
+		if err := ensureMailbox(c, mailbox); err != nil {
+			c.Logout()
+			return nil, err
+		}
+	}
+
+	if _, err := c.Select(i.Config.Mailbox, false); err != nil {
+		c.Logout()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// processMessage fetches a message, stores its reports and then moves it to the
+// processed or failed mailbox. It is left unseen if the storage failed.
+func (i *IMAPInput) processMessage(c *client.Client, uid uint32) error {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	// Peek, so a message that fails to be fetched stays unseen and is retried
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 1)
+	if err := c.UidFetch(seqSet, []imap.FetchItem{section.FetchItem()}, messages); err != nil {
+		return err
+	}
+
+	msg := <-messages
+	if msg == nil {
+		return fmt.Errorf("message %d not found", uid)
+	}
+
+	body := msg.GetBody(section)
+	if body == nil {
+		return fmt.Errorf("message %d has no body", uid)
+	}
+
+	storeErr := storeMessage(i.store, i.Options, body)
+	if storeErr != nil {
+		log.Errorf("Failed to store message %d: %s", uid, storeErr)
+	}
+
+	// The message was peeked, it stays unseen in the mailbox and is retried
+	// once the storage is available again
+	if errors.Is(storeErr, ErrStorage) {
+		return storeErr
+	}
+
+	if err := c.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.SeenFlag}, nil); err != nil {
+		return err
+	}
+
+	destination := i.Config.ProcessedMailbox
+	if storeErr != nil {
+		destination = i.Config.FailedMailbox
+	}
+
+	if destination != "" {
+		if err := c.UidMove(seqSet, destination); err != nil {
+			return err
+		}
+	}
+
+	return storeErr
+}
+
+// ensureMailbox creates the mailbox if it does not exist
+func ensureMailbox(c *client.Client, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", name, mailboxes)
+	}()
+
+	found := false
+	for mailbox := range mailboxes {
+		if mailbox.Name == name {
+			found = true
+		}
+	}
+
+	if err := <-done; err != nil {
+		return err
+	}
+
+	if found {
+		return nil
+	}
+
+	return c.Create(name)
+}
@@ -0,0 +1,186 @@
+package inputs
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// moveBackend adds the MOVE extension, that most real servers support,
+// on top of the memory backend which does not implement it
+type moveBackend struct{ backend.Backend }
+
+func (b moveBackend) Login(info *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(info, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return moveUser{user}, nil
+}
+
+type moveUser struct{ backend.User }
+
+func (u moveUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return moveMailbox{mailbox}, nil
+}
+
+type moveMailbox struct{ backend.Mailbox }
+
+func (m moveMailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	if err := m.CopyMessages(uid, seqSet, dest); err != nil {
+		return err
+	}
+
+	if err := m.UpdateMessagesFlags(uid, seqSet, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+
+	return m.Expunge()
+}
+
+// startTestIMAPServer starts an in-memory IMAP server and returns its address
+func startTestIMAPServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	s := server.New(moveBackend{memory.New()})
+	s.AllowInsecureAuth = true
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+
+	return listener.Addr().String()
+}
+
+func appendTestMessage(t *testing.T, c *client.Client, msg []byte) {
+	t.Helper()
+
+	if err := c.Append("INBOX", nil, time.Now(), bytes.NewReader(msg)); err != nil {
+		t.Fatalf("failed to append message: %s", err)
+	}
+}
+
+func mailboxStatus(t *testing.T, c *client.Client, name string) (uint32, uint32) {
+	t.Helper()
+
+	status, err := c.Status(name, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+	if err != nil {
+		t.Fatalf("failed to get status of %s: %s", name, err)
+	}
+
+	return status.Messages, status.Unseen
+}
+
+func TestIMAPInputProcessAll(t *testing.T) {
+	address := startTestIMAPServer(t)
+	store := newTestStorage(t)
+
+	c, err := client.Dial(address)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer c.Logout()
+
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	appendTestMessage(t, c, newTestMessage(t, "google.com!example.com.xml.gz", "application/gzip", gzipData(t, readTestData(t, "valid5.xml"))))
+	appendTestMessage(t, c, newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid3.xml")))
+	appendTestMessage(t, c, newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed.xml")))
+
+	input, err := NewIMAPInput(IMAPConfig{
+		Address:          address,
+		Username:         "username",
+		Password:         "password",
+		ProcessedMailbox: "Processed",
+		FailedMailbox:    "Failed",
+	}, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	input.ProcessAll(context.Background())
+
+	for reportID, orgName := range map[string]string{"valid5reportid": "google.com", "valid3reportid": "cisco.com"} {
+		if _, err := store.FindReportByReportID(orgName, reportID); err != nil {
+			t.Errorf("expected report %s to be stored: %s", reportID, err)
+		}
+	}
+
+	// The memory backend starts with a single seen message in the INBOX
+	expected := map[string]uint32{"INBOX": 1, "Processed": 2, "Failed": 1}
+	for mailbox, count := range expected {
+		messages, unseen := mailboxStatus(t, c, mailbox)
+		if messages != count {
+			t.Errorf("expected %d messages in %s, got %d", count, mailbox, messages)
+		}
+		if unseen != 0 {
+			t.Errorf("expected no unseen messages in %s, got %d", mailbox, unseen)
+		}
+	}
+}
+
+func TestIMAPInputStorageFailure(t *testing.T) {
+	address := startTestIMAPServer(t)
+
+	c, err := client.Dial(address)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer c.Logout()
+
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatalf("failed to login: %s", err)
+	}
+
+	appendTestMessage(t, c, newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid3.xml")))
+
+	input, err := NewIMAPInput(IMAPConfig{
+		Address:          address,
+		Username:         "username",
+		Password:         "password",
+		ProcessedMailbox: "Processed",
+		FailedMailbox:    "Failed",
+	}, &failingStorage{newTestStorage(t)})
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	input.ProcessAll(context.Background())
+
+	// The message stays unseen in the INBOX, to be retried
+	expected := map[string]uint32{"INBOX": 2, "Processed": 0, "Failed": 0}
+	for mailbox, count := range expected {
+		if messages, _ := mailboxStatus(t, c, mailbox); messages != count {
+			t.Errorf("expected %d messages in %s, got %d", count, mailbox, messages)
+		}
+	}
+
+	// The memory backend does not count unseen messages in the status
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatalf("failed to select INBOX: %s", err)
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	if uids, err := c.UidSearch(criteria); err != nil || len(uids) != 1 {
+		t.Errorf("expected 1 unseen message in INBOX, got %v: %v", uids, err)
+	}
+}
@@ -1,12 +1,34 @@
 package inputs
 
 import (
+	"context"
 	"time"
 )
 
 type Inputer interface {
-	ProcessAll()
+	// ProcessAll processes every pending report, and stops early once the
+	// context is done. The reports being processed are always finished.
+	ProcessAll(ctx context.Context)
 	Process(file string) error
 	StoreReport(data []byte) error
-	Watch(interval time.Duration)
+	// Watch processes new reports until the context is done,
+	// and returns once the reports being processed are stored
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+// poll calls fn on every interval until the context is done
+func poll(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		fn(ctx)
+		timer.Reset(interval)
+	}
 }
@@ -0,0 +1,87 @@
+package inputs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	database_sqlite "github.com/stavros-k/go-dmarc-analyzer/internal/database/sqlite"
+)
+
+// newTestStorage creates a migrated sqlite storage in a temporary directory
+func newTestStorage(t testing.TB) *database_sqlite.SqliteStorage {
+	t.Helper()
+
+	store, err := database_sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	return store
+}
+
+// newTestMessage builds a multipart message with the given attachment, base64 encoded
+func newTestMessage(t *testing.T, name string, contentType string, attachment []byte) []byte {
+	t.Helper()
+
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	lines := []string{}
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+
+	return []byte(strings.Join([]string{
+		"From: noreply-dmarc-support@google.com",
+		"To: dmarc@example.com",
+		"Subject: Report domain: example.com",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="boundary"`,
+		"",
+		"--boundary",
+		"Content-Type: text/html; charset=UTF-8",
+		"",
+		"<html><body>This is an aggregate report</body></html>",
+		"--boundary",
+		"Content-Type: " + contentType + `; name="` + name + `"`,
+		`Content-Disposition: attachment; filename="` + name + `"`,
+		"Content-Transfer-Encoding: base64",
+		"",
+		strings.Join(lines, "\r\n"),
+		"--boundary--",
+		"",
+	}, "\r\n"))
+}
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", name, err)
+	}
+
+	return data
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("failed to gzip: %s", err)
+	}
+	writer.Close()
+
+	return buf.Bytes()
+}
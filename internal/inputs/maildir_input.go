@@ -0,0 +1,139 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+type MaildirInput struct {
+	MaildirPath string
+	// FailedPath is a Maildir++ sub folder that receives the messages that failed
+	FailedPath string
+	Options
+	store        database.Storage
+	mutexProcess sync.Mutex
+}
+
+// NewMaildirInput creates a new MaildirInput
+func NewMaildirInput(maildirPath string, store database.Storage) (*MaildirInput, error) {
+	path := strings.TrimSuffix(maildirPath, "/")
+
+	for _, dir := range []string{path, path + "/.Failed"} {
+		for _, sub := range []string{"", "/cur", "/new", "/tmp"} {
+			if err := os.MkdirAll(dir+sub, 0700); err != nil {
+				log.Errorf("Failed to create directory %s: %s", dir+sub, err)
+				return nil, err
+			}
+		}
+	}
+
+	return &MaildirInput{
+		MaildirPath:  path,
+		FailedPath:   path + "/.Failed",
+		Options:      DefaultOptions,
+		store:        store,
+		mutexProcess: sync.Mutex{},
+	}, nil
+}
+
+// Watch checks the maildir for new messages on a given interval
+func (m *MaildirInput) Watch(ctx context.Context, interval time.Duration) {
+	poll(ctx, interval, m.ProcessAll)
+}
+
+// StoreReport takes a byte slice of a report attachment (xml, gzip or zip),
+// and stores every report it contains in the database
+func (m *MaildirInput) StoreReport(data []byte) error {
+	return storeReport(m.store, m.Options, data)
+}
+
+// ProcessAll processes all the messages in the new/ directory of the maildir
+func (m *MaildirInput) ProcessAll(ctx context.Context) {
+	// Avoid overlapping runs, e.g. ProcessAll called while Watch runs
+	m.mutexProcess.Lock()
+	defer m.mutexProcess.Unlock()
+
+	// The MTA writes to tmp/ and renames into new/,
+	// so everything in new/ is a complete message
+	entries, err := os.ReadDir(m.MaildirPath + "/new")
+	if err != nil {
+		log.Errorf("Failed to read maildir %s: %s", m.MaildirPath, err)
+		return
+	}
+
+	for _, entry := range entries {
+		// The messages left in new/ are processed on the next start
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		file := m.MaildirPath + "/new/" + entry.Name()
+		if err := m.Process(file); err != nil {
+			log.Errorf("Failed to process message %s: %s", file, err)
+		}
+	}
+}
+
+// Process processes a single message file, and moves it to cur/
+// or to the cur/ directory of the failed folder. Messages that failed
+// because of the storage are left in new/.
+func (m *MaildirInput) Process(file string) error {
+	msg, err := os.Open(file)
+	if err != nil {
+		log.Errorf("Failed to open message %s: %s", file, err)
+		return err
+	}
+
+	storeErr := storeMessage(m.store, m.Options, msg)
+	msg.Close()
+
+	// The message stays in new/ and is retried once the storage is available again
+	if errors.Is(storeErr, ErrStorage) {
+		log.Errorf("Failed to store message %s, leaving it for retry: %s", file, storeErr)
+		return storeErr
+	}
+
+	destination := m.MaildirPath
+	if storeErr != nil {
+		log.Errorf("Failed to store message %s: %s", file, storeErr)
+		destination = m.FailedPath
+	}
+
+	if err := os.Rename(file, destination+"/cur/"+maildirSeenName(filepath.Base(file))); err != nil {
+		log.Errorf("Failed to move message %s: %s", file, err)
+		return err
+	}
+
+	return storeErr
+}
+
+// maildirSeenName adds the seen flag to the info part of a maildir file name
+func maildirSeenName(name string) string {
+	base, info, found := strings.Cut(name, ":2,")
+	if !found {
+		return name + ":2,S"
+	}
+
+	if strings.Contains(info, "S") {
+		return name
+	}
+
+	// Flags must be kept in ASCII order
+	flags := []byte(info + "S")
+	slices.Sort(flags)
+
+	return base + ":2," + string(flags)
+}
@@ -0,0 +1,87 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaildirInputProcessAll(t *testing.T) {
+	store := newTestStorage(t)
+	maildir := filepath.Join(t.TempDir(), "Maildir")
+
+	input, err := NewMaildirInput(maildir, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	messages := map[string][]byte{
+		"1695600000.M1P1.mx": newTestMessage(t, "report.xml.gz", "application/gzip", gzipData(t, readTestData(t, "valid5.xml"))),
+		"1695600001.M2P1.mx": newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed2.xml")),
+	}
+	for name, msg := range messages {
+		if err := os.WriteFile(filepath.Join(maildir, "new", name), msg, 0600); err != nil {
+			t.Fatalf("failed to write message: %s", err)
+		}
+	}
+
+	input.ProcessAll(context.Background())
+
+	if _, err := store.FindReportByReportID("google.com", "valid5reportid"); err != nil {
+		t.Errorf("expected report to be stored: %s", err)
+	}
+
+	expected := []string{
+		filepath.Join(maildir, "cur", "1695600000.M1P1.mx:2,S"),
+		filepath.Join(maildir, ".Failed", "cur", "1695600001.M2P1.mx:2,S"),
+	}
+	for _, path := range expected {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected message at %s: %s", path, err)
+		}
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(maildir, "new")); len(entries) != 0 {
+		t.Errorf("expected new/ to be empty, got %d entries", len(entries))
+	}
+}
+
+func TestMaildirSeenName(t *testing.T) {
+	tests := map[string]string{
+		"1695600000.M1P1.mx":      "1695600000.M1P1.mx:2,S",
+		"1695600000.M1P1.mx:2,":   "1695600000.M1P1.mx:2,S",
+		"1695600000.M1P1.mx:2,FT": "1695600000.M1P1.mx:2,FST",
+		"1695600000.M1P1.mx:2,RS": "1695600000.M1P1.mx:2,RS",
+	}
+
+	for name, expected := range tests {
+		if got := maildirSeenName(name); got != expected {
+			t.Errorf("maildirSeenName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestMaildirInputStorageFailure(t *testing.T) {
+	maildir := filepath.Join(t.TempDir(), "Maildir")
+
+	input, err := NewMaildirInput(maildir, &failingStorage{newTestStorage(t)})
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	file := filepath.Join(maildir, "new", "1695600000.M1P1.mx")
+	if err := os.WriteFile(file, newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid5.xml")), 0600); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+
+	// The message is retried once the storage is available again
+	if err := input.Process(file); !errors.Is(err, ErrStorage) {
+		t.Errorf("expected a storage error, got %v", err)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the message to stay in new/: %s", err)
+	}
+}
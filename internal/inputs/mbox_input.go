@@ -0,0 +1,291 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+// mboxLockTimeout is how long to wait for the MTA to release the mbox dot lock
+const mboxLockTimeout = time.Second * 30
+
+type MboxInput struct {
+	MboxPath string
+	// Processed and failed messages are appended to these mbox files
+	ProcessedMboxPath string
+	FailedMboxPath    string
+	Options
+	store        database.Storage
+	mutexProcess sync.Mutex
+}
+
+// NewMboxInput creates a new MboxInput
+func NewMboxInput(mboxPath string, store database.Storage) (*MboxInput, error) {
+	if _, err := os.Stat(mboxPath); err != nil {
+		log.Errorf("Failed to stat mbox %s: %s", mboxPath, err)
+		return nil, err
+	}
+
+	return &MboxInput{
+		MboxPath:          mboxPath,
+		ProcessedMboxPath: mboxPath + ".processed",
+		FailedMboxPath:    mboxPath + ".failed",
+		Options:           DefaultOptions,
+		store:             store,
+		mutexProcess:      sync.Mutex{},
+	}, nil
+}
+
+// Watch checks the mbox for new messages on a given interval
+func (m *MboxInput) Watch(ctx context.Context, interval time.Duration) {
+	poll(ctx, interval, m.ProcessAll)
+}
+
+// StoreReport takes a byte slice of a report attachment (xml, gzip or zip),
+// and stores every report it contains in the database
+func (m *MboxInput) StoreReport(data []byte) error {
+	return storeReport(m.store, m.Options, data)
+}
+
+// ProcessAll processes all the messages in the mbox. The mbox is processed as
+// a whole, so it is always finished once started.
+func (m *MboxInput) ProcessAll(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err := m.Process(m.MboxPath); err != nil {
+		log.Errorf("Failed to process mbox %s: %s", m.MboxPath, err)
+	}
+}
+
+// Process processes every message of an mbox file. Each message is appended
+// to the processed or failed mbox, and removed from the source mbox. Messages
+// that failed because of the storage are kept in the source, to be retried.
+func (m *MboxInput) Process(file string) error {
+	// Avoid overlapping runs, e.g. ProcessAll called while Watch runs
+	m.mutexProcess.Lock()
+	defer m.mutexProcess.Unlock()
+
+	unlock, err := lockMbox(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mbox, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer mbox.Close()
+
+	messages, err := readMbox(mbox)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	kept := []mboxMessage{}
+	var storageErr error
+	for idx, message := range messages {
+		destination := m.ProcessedMboxPath
+		if err := storeMessage(m.store, m.Options, bytes.NewReader(message.body)); err != nil {
+			if errors.Is(err, ErrStorage) {
+				log.Errorf("Failed to store message %d of mbox %s, leaving it for retry: %s", idx, file, err)
+				kept = append(kept, message)
+				storageErr = err
+				continue
+			}
+
+			log.Errorf("Failed to store message %d of mbox %s: %s", idx, file, err)
+			destination = m.FailedMboxPath
+			failed++
+		}
+
+		if err := appendMbox(destination, message); err != nil {
+			// Only the messages that were not appended are left in the source,
+			// so none is lost and none is appended twice on the next run
+			if rewriteErr := rewriteMbox(mbox, append(kept, messages[idx:]...)); rewriteErr != nil {
+				log.Errorf("Failed to rewrite mbox %s: %s", file, rewriteErr)
+			}
+
+			return fmt.Errorf("failed to append message to %s: %w", destination, err)
+		}
+	}
+
+	if err := rewriteMbox(mbox, kept); err != nil {
+		return err
+	}
+
+	if storageErr != nil {
+		return fmt.Errorf("left %d of %d messages for retry: %w", len(kept), len(messages), storageErr)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to store %d of %d messages", failed, len(messages))
+	}
+
+	return nil
+}
+
+type mboxMessage struct {
+	// from is the "From " separator line, without the line ending
+	from string
+	body []byte
+}
+
+// readMbox splits an mbox into messages, reversing the mboxrd ">From " quoting
+func readMbox(r io.Reader) ([]mboxMessage, error) {
+	messages := []mboxMessage{}
+	reader := bufio.NewReader(r)
+
+	var current *mboxMessage
+	var body bytes.Buffer
+	flush := func() {
+		if current != nil {
+			// The separator is preceded by an empty line that is not part of the message
+			current.body = body.Bytes()
+			if bytes.HasSuffix(current.body, []byte("\r\n")) {
+				current.body = bytes.TrimSuffix(current.body, []byte("\r\n"))
+			} else {
+				current.body = bytes.TrimSuffix(current.body, []byte("\n"))
+			}
+			current.body = append([]byte(nil), current.body...)
+			messages = append(messages, *current)
+		}
+		body.Reset()
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case strings.HasPrefix(line, "From "):
+				flush()
+				current = &mboxMessage{from: strings.TrimRight(line, "\r\n")}
+			case current == nil:
+				return nil, errors.New("mbox does not start with a From line")
+			case isQuotedFrom(line):
+				body.WriteString(line[1:])
+			default:
+				body.WriteString(line)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	flush()
+
+	return messages, nil
+}
+
+// isQuotedFrom reports whether the line is a ">From ", ">>From ", ... line
+func isQuotedFrom(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") && strings.HasPrefix(line, ">")
+}
+
+// appendMbox appends a message to an mbox. A partly written message is removed,
+// so the mbox is left as it was when it fails.
+func appendMbox(path string, message mboxMessage) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	writeMboxMessage(writer, message)
+
+	if err := writer.Flush(); err != nil {
+		file.Truncate(info.Size())
+		return err
+	}
+
+	return file.Sync()
+}
+
+// rewriteMbox replaces the content of an open mbox with the given messages
+func rewriteMbox(file *os.File, messages []mboxMessage) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, message := range messages {
+		writeMboxMessage(writer, message)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// writeMboxMessage writes a message with its separator, quoting its From lines.
+// The lines are copied as they are, so CRLF line endings are kept.
+func writeMboxMessage(writer *bufio.Writer, message mboxMessage) {
+	writer.WriteString(message.from + "\n")
+
+	for _, line := range bytes.SplitAfter(message.body, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			writer.WriteByte('>')
+		}
+		writer.Write(line)
+	}
+
+	// The last line may have no line ending
+	if len(message.body) > 0 && !bytes.HasSuffix(message.body, []byte("\n")) {
+		writer.WriteString("\n")
+	}
+	writer.WriteString("\n")
+}
+
+// lockMbox takes the mbox dot lock used by MTAs and mail clients,
+// and returns a function that releases it
+func lockMbox(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(mboxLockTimeout)
+
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for mbox lock %s", lockPath)
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+}
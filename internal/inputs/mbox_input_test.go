@@ -0,0 +1,178 @@
+package inputs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMboxInputProcessAll(t *testing.T) {
+	store := newTestStorage(t)
+	path := filepath.Join(t.TempDir(), "dmarc")
+
+	writeTestMbox(t, path,
+		newTestMessage(t, "report.xml.gz", "application/gzip", gzipData(t, readTestData(t, "valid5.xml"))),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed3.xml")),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid2.xml")),
+	)
+
+	input, err := NewMboxInput(path, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	input.ProcessAll(context.Background())
+
+	for reportID, orgName := range map[string]string{"valid5reportid": "google.com", "valid2reportid": "AMAZON-SES"} {
+		if _, err := store.FindReportByReportID(orgName, reportID); err != nil {
+			t.Errorf("expected report %s to be stored: %s", reportID, err)
+		}
+	}
+
+	expectMboxCounts(t, map[string]int{path: 0, input.ProcessedMboxPath: 2, input.FailedMboxPath: 1})
+}
+
+func TestMboxInputStorageFailure(t *testing.T) {
+	store := newTestStorage(t)
+	path := filepath.Join(t.TempDir(), "dmarc")
+
+	writeTestMbox(t, path,
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid5.xml")),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed3.xml")),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid2.xml")),
+	)
+
+	input, err := NewMboxInput(path, &failingStorage{store})
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	// The reports are kept in the mbox until the storage is available again
+	if err := input.Process(path); !errors.Is(err, ErrStorage) {
+		t.Errorf("expected a storage error, got %v", err)
+	}
+	expectMboxCounts(t, map[string]int{path: 2, input.FailedMboxPath: 1})
+
+	input.store = store
+	if err := input.Process(path); err != nil {
+		t.Errorf("failed to process mbox: %s", err)
+	}
+	expectMboxCounts(t, map[string]int{path: 0, input.ProcessedMboxPath: 2, input.FailedMboxPath: 1})
+}
+
+func TestMboxInputAppendFailure(t *testing.T) {
+	store := newTestStorage(t)
+	path := filepath.Join(t.TempDir(), "dmarc")
+
+	writeTestMbox(t, path,
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid5.xml")),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed3.xml")),
+		newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid2.xml")),
+	)
+
+	input, err := NewMboxInput(path, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	// The failed mbox cannot be written, so the second message cannot be moved
+	if err := os.Mkdir(input.FailedMboxPath, 0700); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := input.Process(path); err == nil {
+		t.Error("expected the append to fail")
+	}
+	expectMboxCounts(t, map[string]int{path: 2, input.ProcessedMboxPath: 1})
+
+	// The message appended before the failure is not appended again
+	if err := os.Remove(input.FailedMboxPath); err != nil {
+		t.Fatalf("failed to remove directory: %s", err)
+	}
+	if err := input.Process(path); err == nil {
+		t.Error("expected the malformed message to fail")
+	}
+	expectMboxCounts(t, map[string]int{path: 0, input.ProcessedMboxPath: 2, input.FailedMboxPath: 1})
+}
+
+func TestAppendMboxKeepsLineEndings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dmarc.processed")
+
+	message := mboxMessage{
+		from: "From a@example.com Sun Sep 24 00:00:00 2023",
+		body: []byte("Subject: one\r\n\r\nFrom the start\r\nlast line"),
+	}
+	if err := appendMbox(path, message); err != nil {
+		t.Fatalf("failed to append message: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read mbox: %s", err)
+	}
+
+	expected := message.from + "\nSubject: one\r\n\r\n>From the start\r\nlast line\n\n"
+	if string(data) != expected {
+		t.Errorf("expected mbox %q, got %q", expected, data)
+	}
+}
+
+func TestReadMboxUnquotesFromLines(t *testing.T) {
+	mbox := "From a@example.com Sun Sep 24 00:00:00 2023\n" +
+		"Subject: one\n\n>From the start\n>>From nested\n\n" +
+		"From b@example.com Sun Sep 24 00:00:00 2023\n" +
+		"Subject: two\n\nbody\n\n"
+
+	messages, err := readMbox(bytes.NewReader([]byte(mbox)))
+	if err != nil {
+		t.Fatalf("failed to read mbox: %s", err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+
+	expected := "Subject: one\n\nFrom the start\n>From nested\n"
+	if string(messages[0].body) != expected {
+		t.Errorf("expected body %q, got %q", expected, messages[0].body)
+	}
+}
+
+// writeTestMbox writes the messages as an mbox, with LF line endings
+func writeTestMbox(t *testing.T, path string, messages ...[]byte) {
+	t.Helper()
+
+	var mbox bytes.Buffer
+	for _, msg := range messages {
+		mbox.WriteString("From MAILER-DAEMON Sun Sep 24 00:00:00 2023\n")
+		mbox.Write(bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n")))
+		mbox.WriteString("\n")
+	}
+
+	if err := os.WriteFile(path, mbox.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write mbox: %s", err)
+	}
+}
+
+// expectMboxCounts checks the number of messages in each mbox
+func expectMboxCounts(t *testing.T, expected map[string]int) {
+	t.Helper()
+
+	for file, count := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", file, err)
+		}
+
+		messages, err := readMbox(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", file, err)
+		}
+
+		if len(messages) != count {
+			t.Errorf("expected %d messages in %s, got %d", count, file, len(messages))
+		}
+	}
+}
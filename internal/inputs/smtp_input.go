@@ -0,0 +1,256 @@
+package inputs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+)
+
+// DefaultSMTPMaxMessageBytes is the default size limit of a single message
+const DefaultSMTPMaxMessageBytes = 32 << 20
+
+// SMTPConfig holds the listener settings of the SMTP/LMTP receiver
+type SMTPConfig struct {
+	// Address to listen on, as host:port
+	Address string
+	// Domain is the host name announced in the greeting
+	Domain string
+	// LMTP serves LMTP instead of SMTP, for delivery from a local MTA
+	LMTP bool
+	// RecipientDomains are the domains messages are accepted for
+	RecipientDomains []string
+	// MaxMessageBytes defaults to DefaultSMTPMaxMessageBytes
+	MaxMessageBytes int
+	// TLSCertFile and TLSKeyFile enable STARTTLS when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+type SMTPInput struct {
+	Config SMTPConfig
+	Options
+	store  database.Storage
+	server *smtp.Server
+	// inFlight are the messages being stored, waited for on shutdown.
+	// Once closing is set, no message is added to it.
+	inFlight      sync.WaitGroup
+	mutexInFlight sync.Mutex
+	closing       bool
+}
+
+// NewSMTPInput creates a new SMTPInput
+func NewSMTPInput(config SMTPConfig, store database.Storage) (*SMTPInput, error) {
+	if config.Address == "" {
+		return nil, errors.New("smtp address is required")
+	}
+
+	if len(config.RecipientDomains) == 0 {
+		return nil, errors.New("smtp recipient domains are required")
+	}
+
+	if config.MaxMessageBytes == 0 {
+		config.MaxMessageBytes = DefaultSMTPMaxMessageBytes
+	}
+
+	input := &SMTPInput{
+		Config:  config,
+		Options: DefaultOptions,
+		store:   store,
+	}
+
+	server := smtp.NewServer(&smtpBackend{input: input})
+	server.Addr = config.Address
+	server.Domain = config.Domain
+	server.LMTP = config.LMTP
+	server.MaxMessageBytes = config.MaxMessageBytes
+	server.MaxRecipients = 50
+	server.ReadTimeout = time.Minute
+	server.WriteTimeout = time.Minute
+	server.AuthDisabled = true
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			log.Errorf("Failed to load smtp certificate %s: %s", config.TLSCertFile, err)
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	input.server = server
+
+	return input, nil
+}
+
+// Watch starts the listener and serves until the context is done. Messages are
+// processed as they are received, so the interval is not used.
+func (s *SMTPInput) Watch(ctx context.Context, interval time.Duration) {
+	log.Infof("Listening for reports on %s", s.Config.Address)
+
+	served := make(chan error, 1)
+	go func() { served <- s.server.ListenAndServe() }()
+
+	select {
+	case err := <-served:
+		log.Errorf("Failed to serve smtp on %s: %s", s.Config.Address, err)
+		return
+	case <-ctx.Done():
+	}
+
+	// Closing drops the connections, the sending MTAs retry the messages
+	// that were not acknowledged, and the ones being stored are finished
+	log.Infof("Stopping smtp listener on %s", s.Config.Address)
+	s.mutexInFlight.Lock()
+	s.closing = true
+	s.mutexInFlight.Unlock()
+
+	s.server.Close()
+	s.inFlight.Wait()
+}
+
+// begin registers a message being stored, it returns false once the listener is stopping
+func (s *SMTPInput) begin() bool {
+	s.mutexInFlight.Lock()
+	defer s.mutexInFlight.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.inFlight.Add(1)
+	return true
+}
+
+// ProcessAll is a no-op, messages are processed as they are received
+func (s *SMTPInput) ProcessAll(ctx context.Context) {}
+
+// Process processes a single message file, for example a message
+// that was saved while the receiver was not reachable
+func (s *SMTPInput) Process(file string) error {
+	msg, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer msg.Close()
+
+	return storeMessage(s.store, s.Options, msg)
+}
+
+// StoreReport takes a byte slice of a report attachment (xml, gzip or zip),
+// and stores every report it contains in the database
+func (s *SMTPInput) StoreReport(data []byte) error {
+	return storeReport(s.store, s.Options, data)
+}
+
+// acceptsRecipient reports whether the address is in one of the recipient domains
+func (s *SMTPInput) acceptsRecipient(address string) bool {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(address[at+1:]), ".")
+	for _, accepted := range s.Config.RecipientDomains {
+		if domain == strings.ToLower(accepted) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type smtpBackend struct {
+	input *SMTPInput
+}
+
+func (b *smtpBackend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	return nil, smtp.ErrAuthUnsupported
+}
+
+// Reports are sent by other MTAs, which do not authenticate
+func (b *smtpBackend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	return &smtpSession{input: b.input, remote: state.RemoteAddr.String()}, nil
+}
+
+type smtpSession struct {
+	input  *SMTPInput
+	remote string
+}
+
+func (s *smtpSession) Reset() {}
+
+func (s *smtpSession) Logout() error {
+	return nil
+}
+
+func (s *smtpSession) Mail(from string, opts smtp.MailOptions) error {
+	if opts.Size > s.input.Config.MaxMessageBytes {
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+			Message:      "Message too big",
+		}
+	}
+
+	return nil
+}
+
+func (s *smtpSession) Rcpt(to string) error {
+	if !s.input.acceptsRecipient(to) {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "Recipient not accepted",
+		}
+	}
+
+	return nil
+}
+
+// Data stores the reports before replying, so the sending MTA keeps
+// the message in its queue when the storage is not available
+func (s *smtpSession) Data(r io.Reader) error {
+	// A message received while stopping is left to the sending MTA to retry
+	if !s.input.begin() {
+		return &smtp.SMTPError{
+			Code:         421,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+			Message:      "Service shutting down, try again later",
+		}
+	}
+	defer s.input.inFlight.Done()
+
+	err := storeMessage(s.input.store, s.input.Options, r)
+	if err == nil {
+		return nil
+	}
+
+	log.Errorf("Failed to store message from %s: %s", s.remote, err)
+
+	if errors.Is(err, ErrStorage) {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary failure storing the report, try again later",
+		}
+	}
+
+	if errors.Is(err, smtp.ErrDataTooLarge) {
+		return smtp.ErrDataTooLarge
+	}
+
+	return &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+		Message:      "Message does not contain a valid report: " + err.Error(),
+	}
+}
@@ -0,0 +1,168 @@
+package inputs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// failingStorage fails every report insert, like a locked or full database
+type failingStorage struct {
+	database.Storage
+}
+
+func (f *failingStorage) CreateReport(*parsers.Report) error {
+	return errors.New("database is locked")
+}
+
+func (f *failingStorage) CreateReportStream(database.ReportStream) error {
+	return errors.New("database is locked")
+}
+
+func startTestSMTPInput(t *testing.T, store database.Storage) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	input, err := NewSMTPInput(SMTPConfig{
+		Address:          address,
+		Domain:           "localhost",
+		RecipientDomains: []string{"example.com"},
+	}, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		input.Watch(ctx, 0)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	// Wait for the listener to be up
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return address
+}
+
+// sendTestMessage sends a message and returns the SMTP reply code of the failing step
+func sendTestMessage(t *testing.T, address string, to string, msg []byte) int {
+	t.Helper()
+
+	c, err := smtp.Dial(address)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer c.Close()
+
+	code := func(err error) int {
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			return protoErr.Code
+		}
+		t.Fatalf("unexpected error: %s", err)
+		return 0
+	}
+
+	if err := c.Mail("noreply-dmarc-support@google.com"); err != nil {
+		return code(err)
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return code(err)
+	}
+
+	writer, err := c.Data()
+	if err != nil {
+		return code(err)
+	}
+	writer.Write(msg)
+	if err := writer.Close(); err != nil {
+		return code(err)
+	}
+
+	return 250
+}
+
+func TestSMTPInputReplies(t *testing.T) {
+	store := newTestStorage(t)
+	address := startTestSMTPInput(t, store)
+	failingAddress := startTestSMTPInput(t, &failingStorage{store})
+
+	valid := newTestMessage(t, "report.xml.gz", "application/gzip", gzipData(t, readTestData(t, "valid5.xml")))
+	invalid := newTestMessage(t, "report.xml", "text/xml", readTestData(t, "malformed.xml"))
+
+	tests := []struct {
+		name     string
+		address  string
+		to       string
+		msg      []byte
+		expected int
+	}{
+		{"valid report", address, "dmarc@example.com", valid, 250},
+		{"unknown recipient domain", address, "dmarc@example.org", valid, 550},
+		{"unparseable report", address, "dmarc@example.com", invalid, 554},
+		{"storage failure", failingAddress, "dmarc@example.com", valid, 451},
+	}
+
+	for _, test := range tests {
+		if got := sendTestMessage(t, test.address, test.to, test.msg); got != test.expected {
+			t.Errorf("%s: expected reply %d, got %d", test.name, test.expected, got)
+		}
+	}
+
+	if _, err := store.FindReportByReportID("google.com", "valid5reportid"); err != nil {
+		t.Errorf("expected report to be stored: %s", err)
+	}
+}
+
+func TestSMTPInputShutdown(t *testing.T) {
+	store := newTestStorage(t)
+
+	input, err := NewSMTPInput(SMTPConfig{Address: "127.0.0.1:0", RecipientDomains: []string{"example.com"}}, store)
+	if err != nil {
+		t.Fatalf("failed to create input: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	input.Watch(ctx, 0)
+
+	// A message received once the listener is stopping is not waited for, so it is deferred
+	session := &smtpSession{input: input, remote: "192.0.2.1:25"}
+	msg := newTestMessage(t, "report.xml", "text/xml", readTestData(t, "valid5.xml"))
+
+	smtpErr := &gosmtp.SMTPError{}
+	if err := session.Data(bytes.NewReader(msg)); !errors.As(err, &smtpErr) || smtpErr.Code != 421 {
+		t.Errorf("expected reply 421, got %v", err)
+	}
+
+	if _, err := store.FindReportByReportID("google.com", "valid5reportid"); err == nil {
+		t.Error("expected the report not to be stored")
+	}
+}
@@ -0,0 +1,267 @@
+package inputs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/attachments"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// Options are the ingestion settings shared by all inputs
+type Options struct {
+	Limits attachments.Limits
+	// Validation selects how reports with invalid records are handled
+	Validation parsers.ValidationMode
+	// Schema selects how reports that do not conform to the schema are handled
+	Schema parsers.SchemaMode
+}
+
+// DefaultOptions keep invalid records, tagged with their validation issues
+var DefaultOptions = Options{
+	Limits:     attachments.DefaultLimits,
+	Validation: parsers.ValidationLenient,
+	Schema:     parsers.SchemaOff,
+}
+
+// ErrStorage wraps errors returned by the storage, as opposed to errors
+// caused by the report itself, so inputs can tell transient failures apart
+var ErrStorage = errors.New("failed to store report")
+
+// storeReport extracts every report in an attachment (xml, json, gzip or zip)
+// and stores it in the database. It is shared by all inputs.
+func storeReport(store database.Storage, opts Options, data []byte) error {
+	payloads, err := attachments.Extract("", data, opts.Limits)
+	if err != nil {
+		return err
+	}
+
+	for _, payload := range payloads {
+		if err := storePayload(store, parsers.Options{Validation: opts.Validation, Schema: opts.Schema}, payload.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storePayload parses a single decompressed report, aggregate or TLS,
+// and stores it in the database
+func storePayload(store database.Storage, opts parsers.Options, data []byte) error {
+	if attachments.Detect(data) == attachments.FormatJSON {
+		return storeTLSReport(store, data)
+	}
+
+	report, err := parsers.NewReportWithOptions(data, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(report.SchemaIssues) > 0 {
+		log.Warnf("Report %s from %s does not conform to the schema: %s",
+			report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, parsers.ValidationErrors(report.SchemaIssues).Error())
+	}
+
+	for idx, record := range report.Records {
+		for _, issue := range record.Issues {
+			log.Warnf("Report %s from %s has an invalid record %d, keeping it: %s",
+				report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, idx, issue.Error())
+		}
+	}
+
+	log.Infof("Saving report %s from %s", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName)
+
+	return savedReport(report, store.CreateReport(report))
+}
+
+// savedReport logs the outcome of storing a report, and returns the errors
+// that are not expected for resent or conflicting reports
+func savedReport(report *parsers.Report, err error) error {
+	if err != nil {
+		if errors.Is(err, database.ErrDuplicateReport) {
+			log.Infof("Report with ID %s from %s already exists, skipping", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName)
+			return nil
+		}
+
+		// The report is stored, flagged for review alongside the one it conflicts with
+		if errors.Is(err, database.ErrConflictingReport) {
+			log.Warnf("Report with ID %s from %s conflicts with a stored report with different content, saved as %s",
+				report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, report.ContentHash)
+			return nil
+		}
+
+		log.Errorf("Failed to save report %s: %s", report.ReportMetadata.ReportID, err)
+		return fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+
+	log.Infof("Saved report %s", report.ReportMetadata.ReportID)
+	return nil
+}
+
+// storeFile streams every report in an attachment file (xml, json, gzip or zip)
+// into the database, without reading whole aggregate reports in memory.
+// Files holding a message, e.g. a failure report saved as an .eml file, are
+// stored like the messages of the mail based inputs.
+func storeFile(store database.Storage, opts Options, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, min(info.Size(), 512))
+	if _, err := file.ReadAt(head, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if attachments.Detect(head) == attachments.FormatMessage {
+		return storeMessage(store, opts, io.NewSectionReader(file, 0, info.Size()))
+	}
+
+	return attachments.ExtractStreams(filepath.Base(file.Name()), file, info.Size(), opts.Limits, func(stream attachments.Stream) error {
+		// TLS reports hold a summary per policy, they are small enough to read at once
+		if stream.Format == attachments.FormatJSON {
+			data, err := io.ReadAll(stream)
+			if err != nil {
+				return err
+			}
+
+			return storeTLSReport(store, data)
+		}
+
+		return storeStream(store, parsers.Options{Validation: opts.Validation, Schema: opts.Schema}, stream)
+	})
+}
+
+// storeStream parses a single decompressed report while storing it in the database
+func storeStream(store database.Storage, opts parsers.Options, r io.Reader) error {
+	// The schema check needs the whole report
+	if opts.Schema == parsers.SchemaWarn || opts.Schema == parsers.SchemaError {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		return storePayload(store, opts, data)
+	}
+
+	decoder, err := parsers.NewReportDecoder(r, opts)
+	if err != nil {
+		return err
+	}
+
+	stream := &loggedStream{ReportDecoder: decoder}
+	report := decoder.Report()
+
+	log.Infof("Saving report %s from %s", report.ReportMetadata.ReportID, report.ReportMetadata.OrgName)
+
+	err = store.CreateReportStream(stream)
+
+	// Errors of the report itself come back through the storage, they are not storage failures.
+	// The storage stops at the first error of the stream, so a failed stream is the cause.
+	if stream.err != nil {
+		return err
+	}
+
+	return savedReport(report, err)
+}
+
+// loggedStream logs the invalid records of a report while it is stored,
+// and keeps the error that ended the stream
+type loggedStream struct {
+	*parsers.ReportDecoder
+	records int
+	err     error
+}
+
+func (s *loggedStream) Next(n int) ([]parsers.Record, error) {
+	records, err := s.ReportDecoder.Next(n)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+
+	report := s.Report()
+	for idx, record := range records {
+		for _, issue := range record.Issues {
+			log.Warnf("Report %s from %s has an invalid record %d, keeping it: %s",
+				report.ReportMetadata.ReportID, report.ReportMetadata.OrgName, s.records+idx, issue.Error())
+		}
+	}
+	s.records += len(records)
+
+	return records, err
+}
+
+// storeMessage stores every report of an RFC 5322 message, the attached
+// aggregate reports and the failure reports. It is shared by the mail based inputs.
+func storeMessage(store database.Storage, opts Options, r io.Reader) error {
+	reports, err := attachments.FromMessage(r, opts.Limits)
+	if err != nil {
+		return err
+	}
+
+	for _, payload := range reports.Payloads {
+		if err := storeReport(store, opts, payload.Data); err != nil {
+			log.Errorf("Failed to store attachment %s: %s", payload.Name, err)
+			return err
+		}
+	}
+
+	for _, failure := range reports.Failures {
+		if err := storeFailureReport(store, failure); err != nil {
+			log.Errorf("Failed to store failure report: %s", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeFailureReport parses a single failure report and stores it in the database
+func storeFailureReport(store database.Storage, failure attachments.FailurePayload) error {
+	report, err := parsers.NewFailureReport(failure.Feedback, failure.Original)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Saving %s failure report for %s from %s", report.AuthFailure, report.ReportedDomain, report.SourceIP)
+
+	if err := store.CreateFailureReport(report); err != nil {
+		if errors.Is(err, database.ErrDuplicateReport) {
+			log.Infof("Failure report %s already exists, skipping", report.ContentHash)
+			return nil
+		}
+
+		log.Errorf("Failed to save failure report %s: %s", report.ContentHash, err)
+		return fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+
+	log.Infof("Saved failure report %s", report.ContentHash)
+	return nil
+}
+
+// storeTLSReport parses a single decompressed TLS report and stores it in the database
+func storeTLSReport(store database.Storage, data []byte) error {
+	report, err := parsers.NewTLSReport(data)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Saving TLS report %s from %s", report.ReportID, report.OrganizationName)
+
+	if err := store.CreateTLSReport(report); err != nil {
+		if errors.Is(err, database.ErrDuplicateReport) {
+			log.Infof("TLS report %s from %s already exists, skipping", report.ReportID, report.OrganizationName)
+			return nil
+		}
+
+		log.Errorf("Failed to save TLS report %s: %s", report.ReportID, err)
+		return fmt.Errorf("%w: %w", ErrStorage, err)
+	}
+
+	log.Infof("Saved TLS report %s", report.ReportID)
+	return nil
+}
@@ -0,0 +1,86 @@
+package inputs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+func TestStoreMessageFailureReport(t *testing.T) {
+	store := newTestStorage(t)
+	message := readTestData(t, "failure.eml")
+
+	// A resend is skipped
+	for i := 0; i < 2; i++ {
+		if err := storeMessage(store, DefaultOptions, bytes.NewReader(message)); err != nil {
+			t.Fatalf("failed to store message: %s", err)
+		}
+	}
+
+	reports, err := store.FindFailureReports("Example.com")
+	if err != nil {
+		t.Fatalf("failed to find failure reports: %s", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 failure report, got %d", len(reports))
+	}
+
+	report := reports[0]
+	if report.AuthFailure != "dmarc" || report.SourceIP != "192.0.2.1" || report.Subject != "Earn money" {
+		t.Errorf("expected a dmarc failure from 192.0.2.1 about Earn money, got %+v", report)
+	}
+
+	if report.BodyExcerpt != "Dear redacted@example.net, reply to redacted@example.com to earn money." {
+		t.Errorf("expected a redacted body excerpt, got %q", report.BodyExcerpt)
+	}
+}
+
+func TestStoreMessageTLSReport(t *testing.T) {
+	store := newTestStorage(t)
+	message := newTestMessage(t, "company-x.example!company-y.example!1459468800!1459555199!001.json.gz",
+		"application/tlsrpt+gzip", gzipData(t, readTestData(t, "tlsrpt.json")))
+
+	// A resend is skipped
+	for i := 0; i < 2; i++ {
+		if err := storeMessage(store, DefaultOptions, bytes.NewReader(message)); err != nil {
+			t.Fatalf("failed to store message: %s", err)
+		}
+	}
+
+	reports, err := store.FindTLSReports("company-y.example")
+	if err != nil {
+		t.Fatalf("failed to find TLS reports: %s", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 TLS report, got %d", len(reports))
+	}
+
+	if details := reports[0].Policies[0].FailureDetails; len(details) != 3 {
+		t.Errorf("expected 3 failure details, got %d", len(details))
+	}
+}
+
+func TestStoreStreamStrict(t *testing.T) {
+	store := newTestStorage(t)
+	opts := parsers.Options{Validation: parsers.ValidationStrict}
+
+	// An invalid record rejects the report, it is not a storage failure to retry
+	err := storeStream(store, opts, bytes.NewReader(readTestData(t, "invalid_record.xml")))
+	var issues parsers.ValidationErrors
+	if !errors.As(err, &issues) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	if errors.Is(err, ErrStorage) {
+		t.Errorf("expected the rejection not to be a storage error, got %v", err)
+	}
+
+	reports, err := store.FindReports()
+	if err != nil {
+		t.Fatalf("failed to find reports: %s", err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("expected no stored reports, got %d", len(reports))
+	}
+}
@@ -0,0 +1,60 @@
+package parsers
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// ErrUnsupportedCharset is returned for reports declaring an unknown character set
+var ErrUnsupportedCharset = errors.New("unsupported character set")
+
+// newXMLDecoder returns a decoder for a raw report, that converts reports
+// declared in another character set (e.g. ISO-8859-1 or Windows-1252) to UTF-8,
+// and tolerates a byte order mark and anything before the first tag
+func newXMLDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(skipPrologue(r))
+	decoder.CharsetReader = charsetReader
+
+	return decoder
+}
+
+// skipPrologue strips a byte order mark, converting UTF-16 reports to UTF-8,
+// and skips whitespace or garbage some reporters send before the XML declaration
+func skipPrologue(r io.Reader) io.Reader {
+	reader := bufio.NewReader(transform.NewReader(r, unicode.BOMOverride(transform.Nop)))
+
+	for {
+		next, err := reader.Peek(1)
+		if err != nil || next[0] == '<' {
+			return reader
+		}
+
+		reader.Discard(1)
+	}
+}
+
+// charsetReader converts the content of a report from the character set
+// of its XML declaration to UTF-8. Labels are matched as web browsers do,
+// so ISO-8859-1 is read as its Windows-1252 superset.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	// UTF-16 reports can only be read with a byte order mark,
+	// which skipPrologue already used to convert them to UTF-8
+	if strings.HasPrefix(strings.ToLower(label), "utf-16") {
+		return input, nil
+	}
+
+	encoding, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCharset, label)
+	}
+
+	return encoding.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,65 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestNewReportCharsets(t *testing.T) {
+	tests := map[string]struct {
+		reportID    string
+		contact     string
+		humanResult string
+	}{
+		"iso-8859-1.xml":   {"iso88591reportid", "Département sécurité", "signature non vérifiée"},
+		"windows-1252.xml": {"windows1252reportid", "Service postmaster – 5 € par mois", "body hash “did not verify”"},
+		"bom.xml":          {"bomreportid", "postmaster@bom.example.org", ""},
+		"utf-16.xml":       {"utf16reportid", "Küchen-Postmaster", ""},
+		"garbage.xml":      {"garbagereportid", "postmaster@garbage.example.org", ""},
+	}
+
+	for name, test := range tests {
+		data := readTestData(t, name)
+
+		report, err := NewReportWithMode(data, ValidationStrict)
+		if err != nil {
+			t.Errorf("%s: failed to parse report: %s", name, err)
+			continue
+		}
+
+		// The streaming decoder reads the same report
+		streamed, err := decodeAll(t, data, ValidationStrict, 10)
+		if err != nil {
+			t.Errorf("%s: failed to decode report: %s", name, err)
+			continue
+		}
+
+		for _, r := range []*Report{report, streamed} {
+			if r.ReportMetadata.ReportID != test.reportID || r.ReportMetadata.ExtraContactInfo != test.contact {
+				t.Errorf("%s: expected report %s with contact %q, got %s with %q",
+					name, test.reportID, test.contact, r.ReportMetadata.ReportID, r.ReportMetadata.ExtraContactInfo)
+			}
+
+			if len(r.Records) != 1 || r.Records[0].AuthResults.DKIM[0].HumanResult != test.humanResult {
+				t.Errorf("%s: expected a record with the DKIM result %q, got %+v", name, test.humanResult, r.Records)
+			}
+		}
+
+		if report.ContentHash != streamed.ContentHash {
+			t.Errorf("%s: expected the same content hash, got %s and %s", name, report.ContentHash, streamed.ContentHash)
+		}
+
+		if report.Format != FormatRFC7489 {
+			t.Errorf("%s: expected format %s, got %s", name, FormatRFC7489, report.Format)
+		}
+	}
+}
+
+func TestNewReportUnsupportedCharset(t *testing.T) {
+	data := bytes.Replace(readTestData(t, "valid5.xml"), []byte(`encoding="UTF-8"`), []byte(`encoding="x-unknown"`), 1)
+
+	if _, err := NewReport(data); !errors.Is(err, ErrUnsupportedCharset) {
+		t.Errorf("expected %s, got %v", ErrUnsupportedCharset, err)
+	}
+}
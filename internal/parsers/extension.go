@@ -0,0 +1,181 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+// Extension is an element the parser does not know, such as an <extension>
+// block or vendor specific data, kept as a raw XML fragment
+type Extension struct {
+	// Name is the local name of the element
+	Name string `json:"name"`
+	// XML is the element with its attributes and content, as it was received.
+	// The namespaces declared on its ancestors are declared on it as well,
+	// so the prefixes it uses can be resolved from the fragment alone.
+	XML string `json:"xml"`
+	// pending is kept until the namespaces declared on the ancestors are known
+	pending *pendingExtension
+}
+
+// pendingExtension is the start element and the raw content of an Extension
+type pendingExtension struct {
+	start xml.StartElement
+	inner []byte
+}
+
+func (e *Extension) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		Inner []byte `xml:",innerxml"`
+	}
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	e.Name = start.Name.Local
+	e.pending = &pendingExtension{start: start.Copy(), inner: raw.Inner}
+	e.XML = e.pending.fragment()
+
+	return nil
+}
+
+func (r *ReportMetadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type reportMetadata ReportMetadata
+	if err := d.DecodeElement((*reportMetadata)(r), &start); err != nil {
+		return err
+	}
+
+	inheritNamespaces(r.Extensions, start.Attr)
+	return nil
+}
+
+func (r *Record) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type record Record
+	if err := d.DecodeElement((*record)(r), &start); err != nil {
+		return err
+	}
+
+	inheritNamespaces(r.Extensions, start.Attr)
+	return nil
+}
+
+// finishExtensions completes the extensions of the whole report with the
+// namespaces declared on the root element
+func (r *Report) finishExtensions(root []xml.Attr) {
+	finishExtensions(r.Extensions, root)
+	finishExtensions(r.ReportMetadata.Extensions, root)
+	for idx := range r.Records {
+		finishExtensions(r.Records[idx].Extensions, root)
+	}
+}
+
+// inheritNamespaces declares the namespaces of an ancestor element on the
+// extensions, unless they declare the same prefix, and rebuilds their XML
+func inheritNamespaces(extensions []Extension, ancestor []xml.Attr) {
+	for idx := range extensions {
+		pending := extensions[idx].pending
+		if pending == nil {
+			continue
+		}
+
+		inherited := []xml.Attr{}
+		for _, attr := range ancestor {
+			if isNamespaceDeclaration(attr) && !declaresPrefix(pending.start.Attr, attr) {
+				inherited = append(inherited, attr)
+			}
+		}
+		pending.start.Attr = append(inherited, pending.start.Attr...)
+
+		extensions[idx].XML = pending.fragment()
+	}
+}
+
+// finishExtensions inherits the namespaces of the root element, the last
+// ancestor of the extensions, and drops what was kept to rebuild them
+func finishExtensions(extensions []Extension, root []xml.Attr) {
+	inheritNamespaces(extensions, root)
+
+	for idx := range extensions {
+		extensions[idx].pending = nil
+	}
+}
+
+// fragment rebuilds the element around its raw content. The decoder resolves
+// prefixes to namespaces, so the element and attribute names get the prefix
+// declared for their namespace back.
+func (p *pendingExtension) fragment() string {
+	var b bytes.Buffer
+
+	name := p.qualify(p.start.Name, true)
+	b.WriteString("<" + name)
+	for _, attr := range p.start.Attr {
+		b.WriteString(" " + p.qualify(attr.Name, false) + `="`)
+		xml.EscapeText(&b, []byte(attr.Value))
+		b.WriteString(`"`)
+	}
+
+	if len(p.inner) == 0 {
+		b.WriteString("/>")
+		return b.String()
+	}
+
+	b.WriteString(">")
+	b.Write(p.inner)
+	b.WriteString("</" + name + ">")
+
+	return b.String()
+}
+
+// qualify returns a name with the prefix declared for its namespace. Unprefixed
+// attributes have no namespace, while element names can be in the default one.
+func (p *pendingExtension) qualify(name xml.Name, element bool) string {
+	switch {
+	case name.Space == "xmlns":
+		return "xmlns:" + name.Local
+	case name.Space == "" && name.Local == "xmlns":
+		return "xmlns"
+	case name.Space == "":
+		return name.Local
+	case name.Space == "http://www.w3.org/XML/1998/namespace":
+		return "xml:" + name.Local
+	}
+
+	for _, attr := range p.start.Attr {
+		if attr.Value != name.Space {
+			continue
+		}
+
+		if attr.Name.Space == "xmlns" {
+			return attr.Name.Local + ":" + name.Local
+		}
+		if element && attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			return name.Local
+		}
+	}
+
+	// The decoder keeps the prefix of an undeclared namespace, which unlike a
+	// namespace name cannot contain a colon
+	if !strings.Contains(name.Space, ":") {
+		return name.Space + ":" + name.Local
+	}
+
+	// The namespace is declared on an ancestor not inherited yet
+	return name.Local
+}
+
+// isNamespaceDeclaration reports whether an attribute is an xmlns or xmlns:prefix declaration
+func isNamespaceDeclaration(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns")
+}
+
+// declaresPrefix reports whether the attributes declare the same prefix as the declaration
+func declaresPrefix(attrs []xml.Attr, declaration xml.Attr) bool {
+	for _, attr := range attrs {
+		if isNamespaceDeclaration(attr) && attr.Name == declaration.Name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,287 @@
+package parsers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FeedbackTypeAuthFailure is the feedback type of DMARC failure reports, RFC 6591
+const FeedbackTypeAuthFailure = "auth-failure"
+
+// ErrUnsupportedFeedbackType is returned for feedback reports that are not
+// failure reports, e.g. abuse reports
+var ErrUnsupportedFeedbackType = errors.New("unsupported feedback type")
+
+// maxBodyExcerpt is the number of bytes of the original body that are kept
+const maxBodyExcerpt = 1024
+
+// maxOriginalText is the number of decoded bytes of the original body that are read
+const maxOriginalText = 4 * maxBodyExcerpt
+
+// maxOriginalDepth is the number of nested multiparts walked in the original body
+const maxOriginalDepth = 5
+
+// FailureReport is a failure report (RUF) about a single message that failed
+// authentication, in the Abuse Reporting Format of RFC 5965 and RFC 6591
+type FailureReport struct {
+	FeedbackType string `json:"feedback_type"`
+	UserAgent    string `json:"user_agent"`
+	Version      string `json:"version"`
+	// AuthFailure is the mechanism that failed: dmarc, dkim, spf, bodyhash, revoked, signature or adsp
+	AuthFailure    string `json:"auth_failure"`
+	ReportedDomain string `json:"reported_domain"`
+	// OriginalReportedDomain is the domain as sent, when it was not in its canonical form
+	OriginalReportedDomain string `json:"original_reported_domain,omitempty"`
+	SourceIP               string `json:"source_ip"`
+	// ArrivalDate is the unix time the message arrived, zero if unknown
+	ArrivalDate           int64       `json:"arrival_date"`
+	ReportingMTA          string      `json:"reporting_mta"`
+	OriginalEnvelopeID    string      `json:"original_envelope_id"`
+	OriginalMailFrom      string      `json:"original_mail_from"`
+	OriginalRcptTo        string      `json:"original_rcpt_to"`
+	DeliveryResult        string      `json:"delivery_result"`
+	AuthenticationResults string      `json:"authentication_results"`
+	IdentityAlignment     string      `json:"identity_alignment"`
+	DKIM                  FailureDKIM `json:"dkim"`
+	SPFDNS                string      `json:"spf_dns"`
+	// The following are read from the original message
+	HeaderFrom      string `json:"header_from"`
+	Subject         string `json:"subject"`
+	MessageID       string `json:"message_id"`
+	OriginalHeaders string `json:"original_headers"`
+	// BodyExcerpt is the start of the original body, with the email addresses redacted
+	BodyExcerpt string `json:"body_excerpt"`
+	// ContentHash is the hex encoded SHA-256 of the feedback fields and the original message
+	ContentHash string `json:"content_hash"`
+}
+
+// FailureDKIM holds the DKIM details of a failure report with a DKIM failure
+type FailureDKIM struct {
+	Domain              string `json:"domain"`
+	Identity            string `json:"identity"`
+	Selector            string `json:"selector"`
+	CanonicalizedHeader string `json:"canonicalized_header"`
+	CanonicalizedBody   string `json:"canonicalized_body"`
+}
+
+// NewFailureReport creates a new FailureReport from the fields of a
+// message/feedback-report part, and the original message or its headers
+func NewFailureReport(feedback []byte, original []byte) (*FailureReport, error) {
+	hash := sha256.New()
+	hash.Write(feedback)
+	hash.Write(original)
+
+	// The fields are a header block, without the blank line that ends it
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(headerBlock(feedback))))
+	fields, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feedback report: %w", err)
+	}
+
+	report := &FailureReport{
+		FeedbackType:          strings.ToLower(fields.Get("Feedback-Type")),
+		UserAgent:             fields.Get("User-Agent"),
+		Version:               fields.Get("Version"),
+		AuthFailure:           strings.ToLower(fields.Get("Auth-Failure")),
+		ReportedDomain:        fields.Get("Reported-Domain"),
+		SourceIP:              fields.Get("Source-IP"),
+		ReportingMTA:          fields.Get("Reporting-MTA"),
+		OriginalEnvelopeID:    fields.Get("Original-Envelope-Id"),
+		OriginalMailFrom:      fields.Get("Original-Mail-From"),
+		OriginalRcptTo:        fields.Get("Original-Rcpt-To"),
+		DeliveryResult:        fields.Get("Delivery-Result"),
+		AuthenticationResults: fields.Get("Authentication-Results"),
+		IdentityAlignment:     fields.Get("Identity-Alignment"),
+		DKIM: FailureDKIM{
+			Domain:              fields.Get("DKIM-Domain"),
+			Identity:            fields.Get("DKIM-Identity"),
+			Selector:            fields.Get("DKIM-Selector"),
+			CanonicalizedHeader: fields.Get("DKIM-Canonicalized-Header"),
+			CanonicalizedBody:   fields.Get("DKIM-Canonicalized-Body"),
+		},
+		SPFDNS: fields.Get("SPF-DNS"),
+	}
+
+	if report.FeedbackType != FeedbackTypeAuthFailure {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFeedbackType, report.FeedbackType)
+	}
+
+	if date, err := mail.ParseDate(fields.Get("Arrival-Date")); err == nil {
+		report.ArrivalDate = date.Unix()
+	}
+
+	report.readOriginal(original)
+	report.ContentHash = hex.EncodeToString(hash.Sum(nil))
+
+	report.Normalize()
+
+	if err := report.Validate(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// readOriginal reads the headers and the start of the body of the original message
+func (r *FailureReport) readOriginal(original []byte) {
+	headers, body := original, []byte{}
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if idx := bytes.Index(original, []byte(separator)); idx >= 0 {
+			headers, body = original[:idx], original[idx+len(separator):]
+			break
+		}
+	}
+
+	r.OriginalHeaders = string(bytes.TrimSpace(headers))
+
+	msg, err := mail.ReadMessage(bytes.NewReader(headerBlock(headers)))
+	if err != nil {
+		r.BodyExcerpt = redactBody(body)
+		return
+	}
+
+	r.BodyExcerpt = redactBody(originalText(textproto.MIMEHeader(msg.Header), bytes.NewReader(body), 0))
+
+	r.Subject = msg.Header.Get("Subject")
+	r.MessageID = msg.Header.Get("Message-Id")
+
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+			r.HeaderFrom = from.Address[at+1:]
+		}
+	}
+
+	// The date of the original message is the next best thing
+	if r.ArrivalDate == 0 {
+		if date, err := msg.Header.Date(); err == nil {
+			r.ArrivalDate = date.Unix()
+		}
+	}
+}
+
+// headerBlock returns a copy of header fields, ended by a blank line
+func headerBlock(fields []byte) []byte {
+	block := bytes.Clone(bytes.TrimSpace(fields))
+
+	return append(block, "\r\n\r\n"...)
+}
+
+// originalText returns the text of the body of the original message, decoded
+// from its transfer encoding and character set. Of a multipart body, the first
+// part with text is used. The original is often truncated, so what could be
+// decoded before an error is kept.
+func originalText(header textproto.MIMEHeader, body io.Reader, depth int) []byte {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 default for parts without a (valid) content type
+		mediaType, params = "text/plain", nil
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxOriginalDepth {
+			return nil
+		}
+
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				return nil
+			}
+
+			if text := originalText(part.Header, part, depth+1); len(bytes.TrimSpace(text)) > 0 {
+				return text
+			}
+		}
+
+	case strings.HasPrefix(mediaType, "text/"):
+		var reader io.Reader = io.LimitReader(decodeTransferEncoding(header, body), maxOriginalText)
+		if label := params["charset"]; label != "" {
+			if decoded, err := charsetReader(label, reader); err == nil {
+				reader = decoded
+			}
+		}
+
+		text, _ := io.ReadAll(reader)
+		return text
+	}
+
+	// Images and other attachments have no text to excerpt
+	return nil
+}
+
+// decodeTransferEncoding wraps a body in a decoder for its Content-Transfer-Encoding
+func decodeTransferEncoding(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+
+	return body
+}
+
+var emailLocalPart = regexp.MustCompile(`[A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@`)
+
+// redactBody returns the start of a body, with the local part of email addresses redacted
+func redactBody(body []byte) string {
+	if len(body) > maxBodyExcerpt {
+		body = body[:maxBodyExcerpt]
+		// Do not cut a character in half
+		for len(body) > 0 && !utf8.Valid(body) {
+			body = body[:len(body)-1]
+		}
+	}
+
+	return emailLocalPart.ReplaceAllString(strings.TrimSpace(string(body)), "redacted@")
+}
+
+// Normalize converts the domains and the IP address of the report to their
+// canonical form, as for aggregate reports
+func (r *FailureReport) Normalize() {
+	r.OriginalReportedDomain = normalizeDomain(&r.ReportedDomain)
+	r.DKIM.Domain = NormalizeDomain(r.DKIM.Domain)
+	r.HeaderFrom = NormalizeDomain(r.HeaderFrom)
+	r.SourceIP = NormalizeIP(r.SourceIP)
+}
+
+// Validate collects every validation problem of the report into ValidationErrors
+func (r *FailureReport) Validate() error {
+	errs := ValidationErrors{}
+
+	// FeedbackType is required and must be auth-failure
+	errs = errs.add("", validateOneOf("feedback_type", r.FeedbackType, FeedbackTypeAuthFailure))
+
+	// AuthFailure is required and must be one of these values
+	errs = errs.add("", validateOneOf("auth_failure", r.AuthFailure, "adsp", "bodyhash", "revoked", "signature", "spf", "dkim", "dmarc"))
+
+	// SourceIP is optional, but must be an IP address if present
+	if r.SourceIP != "" && net.ParseIP(r.SourceIP) == nil {
+		errs = append(errs, ValidationError{
+			Path:    "source_ip",
+			Field:   "source_ip",
+			Value:   r.SourceIP,
+			Rule:    RuleIPAddress,
+			Message: "is not a valid IP address",
+		})
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,126 @@
+package parsers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const failureFeedback = `Feedback-Type: auth-failure
+User-Agent: Example-Reporter/1.0
+Version: 1
+Arrival-Date: Mon, 25 Sep 2023 10:01:58 +0000
+Source-IP: 2001:DB8::1
+Reported-Domain: Example.COM.
+Auth-Failure: dkim
+DKIM-Domain: Example.com
+DKIM-Selector: s1024
+`
+
+const failureOriginal = "From: Sales <sales@example.com>\r\n" +
+	"Subject: Earn money\r\n" +
+	"Message-ID: <8675309@example.com>\r\n" +
+	"\r\n" +
+	"Reply to offers@example.com to earn money.\r\n"
+
+func TestNewFailureReport(t *testing.T) {
+	report, err := NewFailureReport([]byte(failureFeedback), []byte(failureOriginal))
+	if err != nil {
+		t.Fatalf("failed to parse failure report: %s", err)
+	}
+
+	expected := FailureReport{
+		FeedbackType:           FeedbackTypeAuthFailure,
+		UserAgent:              "Example-Reporter/1.0",
+		Version:                "1",
+		AuthFailure:            "dkim",
+		ReportedDomain:         "example.com",
+		OriginalReportedDomain: "Example.COM.",
+		SourceIP:               "2001:db8::1",
+		ArrivalDate:            1695636118,
+		DKIM:                   FailureDKIM{Domain: "example.com", Selector: "s1024"},
+		HeaderFrom:             "example.com",
+		Subject:                "Earn money",
+		MessageID:              "<8675309@example.com>",
+		OriginalHeaders:        "From: Sales <sales@example.com>\r\nSubject: Earn money\r\nMessage-ID: <8675309@example.com>",
+		BodyExcerpt:            "Reply to redacted@example.com to earn money.",
+		ContentHash:            report.ContentHash,
+	}
+	if *report != expected {
+		t.Errorf("expected %+v\ngot %+v", expected, *report)
+	}
+
+	if report.ContentHash == "" {
+		t.Error("expected the report to have a content hash")
+	}
+}
+
+func TestNewFailureReportRejects(t *testing.T) {
+	abuse := strings.Replace(failureFeedback, "auth-failure", "abuse", 1)
+	if _, err := NewFailureReport([]byte(abuse), []byte(failureOriginal)); !errors.Is(err, ErrUnsupportedFeedbackType) {
+		t.Errorf("expected %s, got %v", ErrUnsupportedFeedbackType, err)
+	}
+
+	invalid := strings.NewReplacer("Auth-Failure: dkim", "Auth-Failure: unknown", "Source-IP: 2001:DB8::1", "Source-IP: mx.example.com").Replace(failureFeedback)
+	_, err := NewFailureReport([]byte(invalid), nil)
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Path != "auth_failure" || errs[1].Path != "source_ip" {
+		t.Errorf("expected the auth failure and source IP to be rejected, got %v", err)
+	}
+}
+
+func TestNewFailureReportEncodedOriginal(t *testing.T) {
+	headers := "From: Sales <sales@example.com>\r\nSubject: Earn money\r\nMIME-Version: 1.0\r\n"
+	body := "Reply to offers@example.com to earn money."
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+
+	tests := map[string]struct {
+		original string
+		expected string
+	}{
+		"base64": {
+			headers + "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n" + encoded + "\r\n",
+			"Reply to redacted@example.com to earn money.",
+		},
+		// Originals are often cut, the text decoded until then is kept
+		"truncated base64": {
+			headers + "Content-Transfer-Encoding: base64\r\n\r\n" + encoded[:20] + "\r\n",
+			"Reply to offers",
+		},
+		"quoted-printable": {
+			headers + "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+				"Gr=FC=DFe, reply to offers@example.com =\r\nto earn money.\r\n",
+			"Grüße, reply to redacted@example.com to earn money.",
+		},
+		// The first part with text, after an empty one
+		"multipart": {
+			headers + "Content-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\n\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=\"inner\"\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\n" + encoded + "\r\n" +
+				"--inner\r\nContent-Type: text/html\r\n\r\n<p>" + body + "</p>\r\n--inner--\r\n" +
+				"--outer--\r\n",
+			"Reply to redacted@example.com to earn money.",
+		},
+		"image": {
+			headers + "Content-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\n" + encoded + "\r\n",
+			"",
+		},
+	}
+
+	for name, test := range tests {
+		report, err := NewFailureReport([]byte(failureFeedback), []byte(test.original))
+		if err != nil {
+			t.Fatalf("%s: failed to parse failure report: %s", name, err)
+		}
+
+		if report.BodyExcerpt != test.expected {
+			t.Errorf("%s: expected the body excerpt %q, got %q", name, test.expected, report.BodyExcerpt)
+		}
+		if report.Subject != "Earn money" {
+			t.Errorf("%s: expected the subject Earn money, got %q", name, report.Subject)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package parsers
+
+import (
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeDomain returns the canonical form of a domain: lowercased, without
+// a trailing dot, and with internationalized labels in their A-label (punycode)
+// form. Values that are not valid domain names are only lowercased and trimmed.
+func NormalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if domain == "" {
+		return domain
+	}
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+
+	return strings.ToLower(ascii)
+}
+
+// NormalizeIP returns the canonical text form of an IP address, e.g. IPv6
+// addresses lowercased with their zeros compressed and IPv4-mapped addresses
+// as IPv4. Values that are not IP addresses are returned as they are.
+func NormalizeIP(ip string) string {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ip
+	}
+
+	return addr.Unmap().String()
+}
+
+// normalizeDomain normalizes a domain in place, returning the value as it was
+// sent if it changed, so the original can be kept alongside the canonical form
+func normalizeDomain(domain *string) string {
+	original := *domain
+	*domain = NormalizeDomain(original)
+
+	if *domain == original {
+		return ""
+	}
+
+	return original
+}
+
+// Normalize converts the domains and IP addresses of the report to their
+// canonical form, so the same domain or address is stored the same way
+// whatever form each reporter sends it in
+func (r *Report) Normalize() {
+	r.PolicyPublished.Normalize()
+
+	for idx := range r.Records {
+		r.Records[idx].Normalize()
+	}
+}
+
+func (p *PolicyPublished) Normalize() {
+	p.OriginalDomain = normalizeDomain(&p.Domain)
+}
+
+func (r *Record) Normalize() {
+	r.Row.SourceIP = NormalizeIP(r.Row.SourceIP)
+
+	i := &r.Identifiers
+	i.OriginalEnvelopeTo = normalizeDomain(&i.EnvelopeTo)
+	i.OriginalEnvelopeFrom = normalizeDomain(&i.EnvelopeFrom)
+	i.OriginalHeaderFrom = normalizeDomain(&i.HeaderFrom)
+
+	for idx := range r.AuthResults.DKIM {
+		dkim := &r.AuthResults.DKIM[idx]
+		dkim.OriginalDomain = normalizeDomain(&dkim.Domain)
+	}
+
+	for idx := range r.AuthResults.SPF {
+		spf := &r.AuthResults.SPF[idx]
+		spf.OriginalDomain = normalizeDomain(&spf.Domain)
+	}
+}
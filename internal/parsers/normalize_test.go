@@ -0,0 +1,79 @@
+package parsers
+
+import (
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":           "example.com",
+		"Example.COM.":          "example.com",
+		" example.com ":         "example.com",
+		"bücher.example":        "xn--bcher-kva.example",
+		"BÜCHER.example.":       "xn--bcher-kva.example",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+		"_dmarc.Example.com":    "_dmarc.example.com",
+		"":                      "",
+	}
+
+	for domain, expected := range tests {
+		if normalized := NormalizeDomain(domain); normalized != expected {
+			t.Errorf("expected %q to be normalized to %q, got %q", domain, expected, normalized)
+		}
+	}
+}
+
+func TestNormalizeIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":    "192.0.2.1",
+		"2001:db8::25": "2001:db8::25",
+		"2001:0DB8:0000:0000:0000:0000:0000:0025": "2001:db8::25",
+		"::ffff:192.0.2.1":                        "192.0.2.1",
+		"192.0.2.256":                             "192.0.2.256",
+	}
+
+	for ip, expected := range tests {
+		if normalized := NormalizeIP(ip); normalized != expected {
+			t.Errorf("expected %q to be normalized to %q, got %q", ip, expected, normalized)
+		}
+	}
+}
+
+func TestNewReportNormalizes(t *testing.T) {
+	data := readTestData(t, "unnormalized.xml")
+
+	report, err := NewReportWithMode(data, ValidationStrict)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	streamed, err := decodeAll(t, data, ValidationStrict, 10)
+	if err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+
+	for _, r := range []*Report{report, streamed} {
+		if r.PolicyPublished.Domain != "example.com" || r.PolicyPublished.OriginalDomain != "Example.COM." {
+			t.Errorf("expected the policy domain and its original, got %q and %q", r.PolicyPublished.Domain, r.PolicyPublished.OriginalDomain)
+		}
+
+		first, second := r.Records[0], r.Records[1]
+		if first.Row.SourceIP != "209.85.220.41" || second.Row.SourceIP != "2001:db8::25" {
+			t.Errorf("expected canonical source IPs, got %q and %q", first.Row.SourceIP, second.Row.SourceIP)
+		}
+
+		dkim := first.AuthResults.DKIM[0]
+		if dkim.Domain != "xn--bcher-kva.example" || dkim.OriginalDomain != "bücher.example." {
+			t.Errorf("expected the DKIM domain in its A-label form and its original, got %q and %q", dkim.Domain, dkim.OriginalDomain)
+		}
+
+		// Domains already in their canonical form keep no original
+		if first.AuthResults.DKIM[1].OriginalDomain != "" || first.Identifiers.OriginalHeaderFrom != "" {
+			t.Errorf("expected no original for canonical domains, got %+v", first)
+		}
+
+		if second.Identifiers.EnvelopeFrom != "example.com" || second.Identifiers.OriginalEnvelopeFrom != "EXAMPLE.com" {
+			t.Errorf("expected the envelope from and its original, got %+v", second.Identifiers)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package parsers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The aggregate report schema of RFC 7489, appendix C
+//
+//go:embed schema.xml
+var schemaXML []byte
+
+// SchemaMode selects how reports that do not conform to the schema are handled
+type SchemaMode string
+
+const (
+	// SchemaOff skips the schema check
+	SchemaOff SchemaMode = "off"
+	// SchemaWarn keeps the report, with the schema problems in its SchemaIssues
+	SchemaWarn SchemaMode = "warn"
+	// SchemaError rejects reports that do not conform to the schema
+	SchemaError SchemaMode = "error"
+)
+
+// ParseSchemaMode parses a schema mode, an empty value is off
+func ParseSchemaMode(value string) (SchemaMode, error) {
+	switch SchemaMode(value) {
+	case "", SchemaOff:
+		return SchemaOff, nil
+	case SchemaWarn:
+		return SchemaWarn, nil
+	case SchemaError:
+		return SchemaError, nil
+	}
+
+	return "", fmt.Errorf("schema mode must be one of these values: [%s, %s, %s], got: %s", SchemaOff, SchemaWarn, SchemaError, value)
+}
+
+// Rules only checked against the schema
+const (
+	RuleUnexpected = "unexpected"
+	RuleOrder      = "order"
+	RuleMaxOccurs  = "max_occurs"
+	RuleType       = "type"
+	RulePattern    = "pattern"
+)
+
+// ValidateSchema checks the structure of a raw report against the schema:
+// element presence, ordering, cardinality, enumerations and patterns.
+// It returns ValidationErrors with every problem, or nil if the report conforms.
+func ValidateSchema(b []byte) error {
+	schema, err := loadSchema()
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseXMLTree(b)
+	if err != nil {
+		return err
+	}
+
+	errs := ValidationErrors{}
+	if doc.name != schema.root.name {
+		errs = append(errs, ValidationError{
+			Path:    doc.name,
+			Field:   doc.name,
+			Rule:    RuleUnexpected,
+			Message: "is not expected, the root element must be " + schema.root.name,
+		})
+		return errs
+	}
+
+	// The root element is left out of the paths, as in the other validation errors
+	errs = schema.validateElement(errs, schema.root, doc, "")
+
+	return errs.err()
+}
+
+// loadSchema parses the embedded schema once
+var loadSchema = sync.OnceValues(func() (*xsdSchema, error) {
+	return parseSchema(schemaXML)
+})
+
+// xsdSchema is the subset of XML Schema used by the aggregate report schema
+type xsdSchema struct {
+	complexTypes map[string]*xsdComplexType
+	simpleTypes  map[string]*xsdSimpleType
+	root         *xsdElement
+}
+
+type xsdComplexType struct {
+	// ordered is true for a sequence, false for an all group
+	ordered  bool
+	elements []*xsdElement
+}
+
+type xsdElement struct {
+	name        string
+	typeName    string
+	complexType *xsdComplexType
+	minOccurs   int
+	// maxOccurs is -1 when unbounded
+	maxOccurs int
+}
+
+type xsdSimpleType struct {
+	base        string
+	enumeration []string
+	patterns    []*regexp.Regexp
+}
+
+type rawSchema struct {
+	ComplexTypes []rawComplexType `xml:"complexType"`
+	SimpleTypes  []rawSimpleType  `xml:"simpleType"`
+	Elements     []rawElement     `xml:"element"`
+}
+
+type rawComplexType struct {
+	Name     string    `xml:"name,attr"`
+	Sequence *rawGroup `xml:"sequence"`
+	All      *rawGroup `xml:"all"`
+}
+
+type rawGroup struct {
+	Elements []rawElement `xml:"element"`
+}
+
+type rawElement struct {
+	Name        string          `xml:"name,attr"`
+	Type        string          `xml:"type,attr"`
+	MinOccurs   string          `xml:"minOccurs,attr"`
+	MaxOccurs   string          `xml:"maxOccurs,attr"`
+	ComplexType *rawComplexType `xml:"complexType"`
+}
+
+type rawFacet struct {
+	Value string `xml:"value,attr"`
+}
+
+type rawSimpleType struct {
+	Name        string `xml:"name,attr"`
+	Restriction struct {
+		Base         string     `xml:"base,attr"`
+		Enumerations []rawFacet `xml:"enumeration"`
+		Patterns     []rawFacet `xml:"pattern"`
+	} `xml:"restriction"`
+}
+
+func parseSchema(b []byte) (*xsdSchema, error) {
+	raw := &rawSchema{}
+	if err := xml.Unmarshal(b, raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	schema := &xsdSchema{
+		complexTypes: map[string]*xsdComplexType{},
+		simpleTypes:  map[string]*xsdSimpleType{},
+	}
+
+	for _, rawType := range raw.SimpleTypes {
+		simpleType := &xsdSimpleType{base: rawType.Restriction.Base}
+		for _, enumeration := range rawType.Restriction.Enumerations {
+			simpleType.enumeration = append(simpleType.enumeration, enumeration.Value)
+		}
+
+		for _, pattern := range rawType.Restriction.Patterns {
+			// Patterns are anchored, and the RFC wraps the IP address pattern over several lines
+			re, err := regexp.Compile("^(?:" + strings.Join(strings.Fields(pattern.Value), "") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse schema pattern of %s: %w", rawType.Name, err)
+			}
+			simpleType.patterns = append(simpleType.patterns, re)
+		}
+
+		schema.simpleTypes[rawType.Name] = simpleType
+	}
+
+	for idx := range raw.ComplexTypes {
+		complexType, err := parseComplexType(&raw.ComplexTypes[idx])
+		if err != nil {
+			return nil, err
+		}
+		schema.complexTypes[raw.ComplexTypes[idx].Name] = complexType
+	}
+
+	if len(raw.Elements) != 1 {
+		return nil, fmt.Errorf("schema must have a single root element, got %d", len(raw.Elements))
+	}
+
+	root, err := parseElement(&raw.Elements[0])
+	if err != nil {
+		return nil, err
+	}
+	schema.root = root
+
+	return schema, nil
+}
+
+func parseComplexType(raw *rawComplexType) (*xsdComplexType, error) {
+	group, ordered := raw.All, false
+	if raw.Sequence != nil {
+		group, ordered = raw.Sequence, true
+	}
+
+	complexType := &xsdComplexType{ordered: ordered}
+	if group == nil {
+		return complexType, nil
+	}
+
+	for idx := range group.Elements {
+		element, err := parseElement(&group.Elements[idx])
+		if err != nil {
+			return nil, err
+		}
+		complexType.elements = append(complexType.elements, element)
+	}
+
+	return complexType, nil
+}
+
+func parseElement(raw *rawElement) (*xsdElement, error) {
+	element := &xsdElement{name: raw.Name, typeName: raw.Type, minOccurs: 1, maxOccurs: 1}
+
+	if raw.MinOccurs != "" {
+		minOccurs, err := strconv.Atoi(raw.MinOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minOccurs of %s: %w", raw.Name, err)
+		}
+		element.minOccurs = minOccurs
+	}
+
+	switch raw.MaxOccurs {
+	case "":
+	case "unbounded":
+		element.maxOccurs = -1
+	default:
+		maxOccurs, err := strconv.Atoi(raw.MaxOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxOccurs of %s: %w", raw.Name, err)
+		}
+		element.maxOccurs = maxOccurs
+	}
+
+	if raw.ComplexType != nil {
+		complexType, err := parseComplexType(raw.ComplexType)
+		if err != nil {
+			return nil, err
+		}
+		element.complexType = complexType
+	}
+
+	return element, nil
+}
+
+// xmlNode is an element of a document, namespaces are ignored
+type xmlNode struct {
+	name     string
+	text     string
+	children []*xmlNode
+}
+
+func parseXMLTree(b []byte) (*xmlNode, error) {
+	decoder := newXMLDecoder(bytes.NewReader(b))
+
+	var root *xmlNode
+	stack := []*xmlNode{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("document has no root element")
+	}
+
+	return root, nil
+}
+
+func (s *xsdSchema) validateElement(errs ValidationErrors, element *xsdElement, node *xmlNode, path string) ValidationErrors {
+	complexType := element.complexType
+	if complexType == nil {
+		complexType = s.complexTypes[element.typeName]
+	}
+
+	if complexType != nil {
+		return s.validateComplex(errs, complexType, node, path)
+	}
+
+	return s.validateSimple(errs, element, node, path)
+}
+
+func (s *xsdSchema) validateComplex(errs ValidationErrors, complexType *xsdComplexType, node *xmlNode, path string) ValidationErrors {
+	positions := make(map[string]int, len(complexType.elements))
+	for idx, element := range complexType.elements {
+		positions[element.name] = idx
+	}
+
+	counts := map[string]int{}
+	last := -1
+	for _, child := range node.children {
+		childPath := joinPath(path, child.name)
+
+		position, ok := positions[child.name]
+		if !ok {
+			errs = append(errs, ValidationError{Path: childPath, Field: child.name, Rule: RuleUnexpected, Message: "is not expected"})
+			continue
+		}
+
+		element := complexType.elements[position]
+		if element.maxOccurs != 1 {
+			childPath = fmt.Sprintf("%s[%d]", childPath, counts[child.name])
+		}
+		counts[child.name]++
+
+		if complexType.ordered && position < last {
+			errs = append(errs, ValidationError{
+				Path:    childPath,
+				Field:   child.name,
+				Rule:    RuleOrder,
+				Message: "must come before " + complexType.elements[last].name,
+			})
+		}
+		last = max(last, position)
+
+		// Report the first extra occurrence only
+		if element.maxOccurs >= 0 && counts[child.name] == element.maxOccurs+1 {
+			errs = append(errs, ValidationError{
+				Path:    childPath,
+				Field:   child.name,
+				Rule:    RuleMaxOccurs,
+				Message: fmt.Sprintf("must occur at most %d times", element.maxOccurs),
+			})
+		}
+
+		errs = s.validateElement(errs, element, child, childPath)
+	}
+
+	for _, element := range complexType.elements {
+		if counts[element.name] < element.minOccurs {
+			errs = append(errs, ValidationError{
+				Path:    joinPath(path, element.name),
+				Field:   element.name,
+				Rule:    RuleRequired,
+				Message: "is required",
+			})
+		}
+	}
+
+	return errs
+}
+
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+
+func (s *xsdSchema) validateSimple(errs ValidationErrors, element *xsdElement, node *xmlNode, path string) ValidationErrors {
+	if len(node.children) > 0 {
+		return append(errs, ValidationError{Path: path, Field: element.name, Rule: RuleType, Message: "must not have child elements"})
+	}
+
+	value := strings.TrimSpace(node.text)
+	invalid := func(rule string, message string) ValidationErrors {
+		return append(errs, ValidationError{Path: path, Field: element.name, Value: value, Rule: rule, Message: message})
+	}
+
+	simpleType := s.simpleTypes[element.typeName]
+	base := element.typeName
+	if simpleType != nil {
+		base = simpleType.base
+	}
+
+	switch base {
+	case "xs:integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return invalid(RuleType, "must be an integer")
+		}
+	case "xs:decimal":
+		if !decimalPattern.MatchString(value) {
+			return invalid(RuleType, "must be a decimal number")
+		}
+	}
+
+	if simpleType == nil {
+		return errs
+	}
+
+	if len(simpleType.enumeration) > 0 && !slices.Contains(simpleType.enumeration, value) {
+		return invalid(RuleOneOf, "must be one of these values: ["+strings.Join(simpleType.enumeration, ", ")+"]")
+	}
+
+	for _, pattern := range simpleType.patterns {
+		if !pattern.MatchString(value) {
+			return invalid(RulePattern, "must match the pattern of "+element.typeName)
+		}
+	}
+
+	return errs
+}
+
+func joinPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
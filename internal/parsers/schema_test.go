@@ -0,0 +1,110 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const conformingReport = `<?xml version="1.0" encoding="UTF-8"?>
+<feedback xmlns="http://dmarc.org/dmarc-xml/0.1">
+  <version>1.0</version>
+  <report_metadata>
+    <org_name>example.net</org_name>
+    <email>dmarc@example.net</email>
+    <report_id>1</report_id>
+    <date_range><end>1697846399</end><begin>1697760000</begin></date_range>
+  </report_metadata>
+  <policy_published>
+    <domain>example.com</domain>
+    <p>reject</p>
+    <sp>none</sp>
+    <pct>100</pct>
+    <fo>0</fo>
+  </policy_published>
+  <record>
+    <row>
+      <source_ip>192.0.2.1</source_ip>
+      <count>1</count>
+      <policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>fail</spf></policy_evaluated>
+    </row>
+    <identifiers><header_from>example.com</header_from><envelope_from>example.com</envelope_from></identifiers>
+    <auth_results>
+      <dkim><domain>example.com</domain><result>pass</result></dkim>
+      <spf><domain>example.com</domain><scope>mfrom</scope><result>fail</result></spf>
+    </auth_results>
+  </record>
+</feedback>`
+
+func TestValidateSchemaConforming(t *testing.T) {
+	if err := ValidateSchema([]byte(conformingReport)); err != nil {
+		t.Errorf("expected the report to conform, got %s", err)
+	}
+}
+
+func TestValidateSchemaProblems(t *testing.T) {
+	report := strings.NewReplacer(
+		"<version>1.0</version>", "<version>one</version>",
+		"<sp>none</sp>", "<sp>none</sp><sp>none</sp>",
+		"<pct>100</pct>", "<pct>all</pct>",
+		"<source_ip>192.0.2.1</source_ip>", "<source_ip>2001:db8::1</source_ip>",
+		"<dkim>pass</dkim><spf>fail</spf>", "<spf>fail</spf><dkim>pass</dkim>",
+		"<envelope_from>example.com</envelope_from>", "<envelope_from>example.com</envelope_from><mail_from>x</mail_from>",
+		"<scope>mfrom</scope>", "<scope>envelope</scope>",
+		"<domain>example.com</domain><result>pass</result>", "<result>pass</result>",
+	).Replace(conformingReport)
+
+	err := ValidateSchema([]byte(report))
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		"version: type",
+		"policy_published.sp: max_occurs",
+		"policy_published.pct: type",
+		"record[0].row.source_ip: pattern",
+		"record[0].row.policy_evaluated.dkim: order",
+		"record[0].identifiers.mail_from: unexpected",
+		"record[0].auth_results.dkim[0].domain: required",
+		"record[0].auth_results.spf[0].scope: one_of",
+	}
+
+	problems := make([]string, len(errs))
+	for idx, validationErr := range errs {
+		problems[idx] = fmt.Sprintf("%s: %s", validationErr.Path, validationErr.Rule)
+	}
+
+	if !slices.Equal(problems, expected) {
+		t.Errorf("expected the problems\n%v\ngot\n%v", expected, problems)
+	}
+}
+
+func TestNewReportSchemaMode(t *testing.T) {
+	// valid5.xml has a compressed IPv6 address, that the RFC pattern does not accept
+	data := readTestData(t, "valid5.xml")
+
+	report, err := NewReportWithOptions(data, Options{Schema: SchemaOff})
+	if err != nil || len(report.SchemaIssues) != 0 {
+		t.Errorf("expected no schema check, got %v and %v", err, report.SchemaIssues)
+	}
+
+	report, err = NewReportWithOptions(data, Options{Schema: SchemaWarn})
+	if err != nil {
+		t.Fatalf("expected the report to be kept, got %s", err)
+	}
+	if len(report.SchemaIssues) == 0 {
+		t.Error("expected the report to have schema issues")
+	}
+
+	_, err = NewReportWithOptions(data, Options{Schema: SchemaError})
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) || len(errs) != len(report.SchemaIssues) {
+		t.Errorf("expected the report to be rejected with %d problems, got %v", len(report.SchemaIssues), err)
+	}
+}
@@ -0,0 +1,207 @@
+package parsers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrStreamSchema is returned by NewReportDecoder when a schema check is requested,
+// as it needs the whole report in memory
+var ErrStreamSchema = errors.New("schema checks are not supported while streaming a report")
+
+// ReportDecoder reads a report from a stream without holding all of its records
+// in memory. The report is available once created, the records follow in chunks.
+type ReportDecoder struct {
+	decoder *xml.Decoder
+	// reader is the stream as received, read to its end once the root element closes
+	reader io.Reader
+	hash   hash.Hash
+	mode   ValidationMode
+	report *Report
+	// next is the start of the first record, read while decoding the report
+	next *xml.StartElement
+	// records is the number of records decoded so far
+	records int
+	// errs are the problems of the records, returned at the end in strict mode
+	errs ValidationErrors
+	// namespaces are the attributes of the root element, declared on the extensions
+	namespaces []xml.Attr
+	done       bool
+}
+
+// NewReportDecoder reads and validates everything up to the first record of a
+// report. Problems outside of the records reject the report, as in NewReportWithOptions.
+func NewReportDecoder(r io.Reader, opts Options) (*ReportDecoder, error) {
+	if opts.Schema == SchemaWarn || opts.Schema == SchemaError {
+		return nil, ErrStreamSchema
+	}
+
+	d := &ReportDecoder{
+		hash:   sha256.New(),
+		mode:   opts.Validation,
+		report: &Report{},
+	}
+	// The content hash covers the report as received, before any conversion
+	d.reader = io.TeeReader(r, d.hash)
+	d.decoder = newXMLDecoder(d.reader)
+
+	root, err := rootElement(d.decoder)
+	if err != nil {
+		return nil, err
+	}
+	d.namespaces = root.Attr
+
+	for d.next == nil && !d.done {
+		start, err := d.child()
+		if err != nil {
+			return nil, err
+		}
+		if start == nil {
+			continue
+		}
+
+		if start.Name.Local == "record" {
+			d.next = start
+			continue
+		}
+
+		if err := d.decodeChild(start); err != nil {
+			return nil, err
+		}
+	}
+
+	d.report.Format = d.report.detectFormat(root.Name.Space)
+	d.report.PolicyPublished.Normalize()
+
+	if errs := d.report.validateHeader(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	return d, nil
+}
+
+// Report returns the report without its records. Its content hash, and the
+// elements after the records, are only set once Next has returned io.EOF.
+func (d *ReportDecoder) Report() *Report {
+	return d.report
+}
+
+// Next returns up to n records, validated according to the validation mode.
+// It returns io.EOF after the last record, or in strict mode the problems of
+// every invalid record instead.
+func (d *ReportDecoder) Next(n int) ([]Record, error) {
+	records := []Record{}
+	for len(records) < n && !d.done {
+		start := d.next
+		d.next = nil
+
+		if start == nil {
+			var err error
+			if start, err = d.child(); err != nil {
+				return nil, err
+			}
+			if start == nil {
+				continue
+			}
+		}
+
+		// Anything but a record after the records is kept as an extension
+		if start.Name.Local != "record" {
+			if err := d.decodeExtension(start); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		record := Record{}
+		if err := d.decoder.DecodeElement(&record, start); err != nil {
+			return nil, err
+		}
+		finishExtensions(record.Extensions, d.namespaces)
+
+		record.Normalize()
+		d.errs = append(d.errs, record.validateAt(d.records, d.mode)...)
+		d.records++
+		records = append(records, record)
+	}
+
+	if len(records) > 0 {
+		return records, nil
+	}
+
+	if len(d.errs) > 0 {
+		return nil, d.errs
+	}
+
+	return nil, io.EOF
+}
+
+// child reads the next token of the root element, returning the start of a
+// child element or nil for anything else. At the end of the root element, it
+// reads the rest of the stream so the content hash covers all of it. Like
+// NewReport, it ignores whatever follows the root element.
+func (d *ReportDecoder) child() (*xml.StartElement, error) {
+	token, err := d.decoder.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case xml.StartElement:
+		return &t, nil
+	case xml.EndElement:
+		return nil, d.finish()
+	}
+
+	return nil, nil
+}
+
+func (d *ReportDecoder) finish() error {
+	if _, err := io.Copy(io.Discard, d.reader); err != nil {
+		return err
+	}
+
+	d.report.ContentHash = hex.EncodeToString(d.hash.Sum(nil))
+	d.done = true
+
+	return nil
+}
+
+// decodeChild decodes an element of the report found before the records
+func (d *ReportDecoder) decodeChild(start *xml.StartElement) error {
+	switch start.Name.Local {
+	case "version":
+		return d.decoder.DecodeElement(&d.report.Version, start)
+	case "report_metadata":
+		if err := d.decoder.DecodeElement(&d.report.ReportMetadata, start); err != nil {
+			return err
+		}
+		finishExtensions(d.report.ReportMetadata.Extensions, d.namespaces)
+		return nil
+	case "policy_published":
+		return d.decoder.DecodeElement(&d.report.PolicyPublished, start)
+	}
+
+	return d.decodeExtension(start)
+}
+
+// decodeExtension decodes an unknown element of the report
+func (d *ReportDecoder) decodeExtension(start *xml.StartElement) error {
+	extension := Extension{}
+	if err := d.decoder.DecodeElement(&extension, start); err != nil {
+		return err
+	}
+
+	extensions := []Extension{extension}
+	finishExtensions(extensions, d.namespaces)
+	d.report.Extensions = append(d.report.Extensions, extensions...)
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// decodeAll reads a whole report with a ReportDecoder, in chunks of n records
+func decodeAll(t *testing.T, data []byte, mode ValidationMode, n int) (*Report, error) {
+	t.Helper()
+
+	decoder, err := NewReportDecoder(bytes.NewReader(data), Options{Validation: mode})
+	if err != nil {
+		return nil, err
+	}
+
+	report := decoder.Report()
+	for {
+		records, err := decoder.Next(n)
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) > n {
+			t.Fatalf("expected at most %d records, got %d", n, len(records))
+		}
+		report.Records = append(report.Records, records...)
+	}
+}
+
+func TestReportDecoder(t *testing.T) {
+	for _, name := range []string{"valid1.xml", "valid5.xml", "invalid_record.xml", "dmarcbis.xml", "extensions.xml"} {
+		data := readTestData(t, name)
+
+		expected, err := NewReport(data)
+		if err != nil {
+			t.Fatalf("%s: failed to parse report: %s", name, err)
+		}
+
+		report, err := decodeAll(t, data, ValidationLenient, 1)
+		if err != nil {
+			t.Fatalf("%s: failed to decode report: %s", name, err)
+		}
+
+		if !reflect.DeepEqual(report, expected) {
+			t.Errorf("%s: expected the decoded report to match the parsed one\n%+v\ngot\n%+v", name, expected, report)
+		}
+	}
+}
+
+func TestReportDecoderStrict(t *testing.T) {
+	_, err := decodeAll(t, readTestData(t, "invalid_record.xml"), ValidationStrict, 10)
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	if len(errs) != 1 || errs[0] != invalidScope {
+		t.Errorf("expected %+v, got %+v", invalidScope, errs)
+	}
+}
+
+func TestReportDecoderRejects(t *testing.T) {
+	// Problems outside of the records are found before any record is read
+	data := bytes.Replace(readTestData(t, "valid5.xml"), []byte("<p>reject</p>"), []byte("<p>block</p>"), 1)
+
+	_, err := NewReportDecoder(bytes.NewReader(data), Options{})
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "policy_published.p" {
+		t.Errorf("expected the policy to be rejected, got %v", err)
+	}
+
+	if _, err := NewReportDecoder(bytes.NewReader(data), Options{Schema: SchemaWarn}); !errors.Is(err, ErrStreamSchema) {
+		t.Errorf("expected %s, got %v", ErrStreamSchema, err)
+	}
+
+	// A truncated report fails while reading its records
+	data = readTestData(t, "valid5.xml")
+	if _, err := decodeAll(t, data[:len(data)-200], ValidationLenient, 10); err == nil {
+		t.Error("expected a truncated report to fail")
+	}
+}
+
+func TestReportDecoderTrailingData(t *testing.T) {
+	// Bytes after the root element are ignored, as they are by NewReport
+	data := append(readTestData(t, "valid5.xml"), "\n</feedback>\x00garbage"...)
+
+	expected, err := NewReport(data)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	report, err := decodeAll(t, data, ValidationLenient, 10)
+	if err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected the decoded report to match the parsed one\n%+v\ngot\n%+v", expected, report)
+	}
+}
@@ -0,0 +1,200 @@
+package parsers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// TLS policy types
+const (
+	TLSPolicyTypeSTS      = "sts"
+	TLSPolicyTypeTLSA     = "tlsa"
+	TLSPolicyTypeNoPolicy = "no-policy-found"
+)
+
+// TLSReport is an SMTP TLS report (TLS-RPT), RFC 8460 section 4.
+// The JSON tags are the field names of the RFC, so the API returns the report as it was sent.
+type TLSReport struct {
+	OrganizationName string       `json:"organization-name"`
+	DateRange        TLSDateRange `json:"date-range"`
+	ContactInfo      string       `json:"contact-info"`
+	ReportID         string       `json:"report-id"`
+	Policies         []TLSPolicy  `json:"policies"`
+	// ContentHash is the hex encoded SHA-256 of the raw report
+	ContentHash string `json:"content-hash,omitempty"`
+}
+
+type TLSDateRange struct {
+	StartDatetime time.Time `json:"start-datetime"`
+	EndDatetime   time.Time `json:"end-datetime"`
+}
+
+// TLSPolicy holds the sessions of a single policy, with the details of the failed ones
+type TLSPolicy struct {
+	Policy         TLSPolicyDetails   `json:"policy"`
+	Summary        TLSSummary         `json:"summary"`
+	FailureDetails []TLSFailureDetail `json:"failure-details,omitempty"`
+}
+
+type TLSPolicyDetails struct {
+	// PolicyType is one of the TLSPolicyType* values
+	PolicyType string `json:"policy-type"`
+	// PolicyString is the policy as fetched, one line or record per item
+	PolicyString []string `json:"policy-string,omitempty"`
+	PolicyDomain string   `json:"policy-domain"`
+	// OriginalPolicyDomain is the domain as sent, when it was not in its canonical form
+	OriginalPolicyDomain string `json:"original-policy-domain,omitempty"`
+	// MXHost are the MX patterns of an MTA-STS policy
+	MXHost []string `json:"mx-host,omitempty"`
+}
+
+type TLSSummary struct {
+	TotalSuccessfulSessionCount int64 `json:"total-successful-session-count"`
+	TotalFailureSessionCount    int64 `json:"total-failure-session-count"`
+}
+
+type TLSFailureDetail struct {
+	ResultType            string `json:"result-type"`
+	SendingMTAIP          string `json:"sending-mta-ip,omitempty"`
+	ReceivingMXHostname   string `json:"receiving-mx-hostname,omitempty"`
+	ReceivingMXHelo       string `json:"receiving-mx-helo,omitempty"`
+	ReceivingIP           string `json:"receiving-ip,omitempty"`
+	FailedSessionCount    int64  `json:"failed-session-count"`
+	AdditionalInformation string `json:"additional-information,omitempty"`
+	FailureReasonCode     string `json:"failure-reason-code,omitempty"`
+}
+
+// NewTLSReport creates a new TLSReport from a decompressed JSON report,
+// rejecting reports with any validation problem
+func NewTLSReport(data []byte) (*TLSReport, error) {
+	hash := sha256.Sum256(data)
+
+	report := &TLSReport{}
+	// Reporters may send a byte order mark, which is not valid JSON
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), report); err != nil {
+		return nil, fmt.Errorf("failed to decode TLS report: %w", err)
+	}
+
+	report.ContentHash = hex.EncodeToString(hash[:])
+
+	report.Normalize()
+
+	if err := report.Validate(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Normalize converts the domains and IP addresses of the report to their
+// canonical form, as for aggregate reports
+func (r *TLSReport) Normalize() {
+	for idx := range r.Policies {
+		policy := &r.Policies[idx]
+		policy.Policy.OriginalPolicyDomain = normalizeDomain(&policy.Policy.PolicyDomain)
+
+		for idx := range policy.FailureDetails {
+			detail := &policy.FailureDetails[idx]
+			detail.SendingMTAIP = NormalizeIP(detail.SendingMTAIP)
+			detail.ReceivingIP = NormalizeIP(detail.ReceivingIP)
+			detail.ReceivingMXHostname = NormalizeDomain(detail.ReceivingMXHostname)
+		}
+	}
+}
+
+// Validate collects every validation problem of the report into ValidationErrors
+func (r *TLSReport) Validate() error {
+	errs := ValidationErrors{}
+
+	// OrganizationName is required
+	errs = errs.add("", validateRequired("organization-name", r.OrganizationName))
+
+	// ReportID is required
+	errs = errs.add("", validateRequired("report-id", r.ReportID))
+
+	// DateRange is required, and must not end before it starts
+	switch {
+	case r.DateRange.StartDatetime.IsZero():
+		errs = errs.add("date-range", validateRequired("start-datetime", ""))
+	case r.DateRange.EndDatetime.IsZero():
+		errs = errs.add("date-range", validateRequired("end-datetime", ""))
+	case r.DateRange.EndDatetime.Before(r.DateRange.StartDatetime):
+		errs = append(errs, ValidationError{
+			Path:    "date-range.end-datetime",
+			Field:   "end-datetime",
+			Value:   r.DateRange.EndDatetime.Format(time.RFC3339),
+			Rule:    RuleRange,
+			Message: "must not be before start-datetime",
+		})
+	}
+
+	for idx := range r.Policies {
+		errs = errs.add(fmt.Sprintf("policies[%d]", idx), r.Policies[idx].Validate())
+	}
+
+	return errs.err()
+}
+
+func (p *TLSPolicy) Validate() error {
+	errs := ValidationErrors{}
+
+	// PolicyType is required and must be one of these values
+	errs = errs.add("policy", validateOneOf("policy-type", p.Policy.PolicyType, TLSPolicyTypeSTS, TLSPolicyTypeTLSA, TLSPolicyTypeNoPolicy))
+
+	// PolicyDomain is required
+	errs = errs.add("policy", validateRequired("policy-domain", p.Policy.PolicyDomain))
+
+	errs = append(errs, validateCount("summary.total-successful-session-count", "total-successful-session-count", p.Summary.TotalSuccessfulSessionCount)...)
+	errs = append(errs, validateCount("summary.total-failure-session-count", "total-failure-session-count", p.Summary.TotalFailureSessionCount)...)
+
+	for idx := range p.FailureDetails {
+		errs = errs.add(fmt.Sprintf("failure-details[%d]", idx), p.FailureDetails[idx].Validate())
+	}
+
+	return errs.err()
+}
+
+func (d *TLSFailureDetail) Validate() error {
+	errs := ValidationErrors{}
+
+	// ResultType is required, reporters may use result types of later specifications
+	errs = errs.add("", validateRequired("result-type", d.ResultType))
+
+	// The IP addresses are optional, but must be IP addresses if present
+	for _, ip := range []struct{ field, value string }{{"sending-mta-ip", d.SendingMTAIP}, {"receiving-ip", d.ReceivingIP}} {
+		if ip.value != "" && net.ParseIP(ip.value) == nil {
+			errs = append(errs, ValidationError{
+				Path:    ip.field,
+				Field:   ip.field,
+				Value:   ip.value,
+				Rule:    RuleIPAddress,
+				Message: "is not a valid IP address",
+			})
+		}
+	}
+
+	errs = append(errs, validateCount("failed-session-count", "failed-session-count", d.FailedSessionCount)...)
+
+	return errs.err()
+}
+
+// validateCount checks that a session count is not negative
+func validateCount(path string, field string, count int64) ValidationErrors {
+	if count >= 0 {
+		return nil
+	}
+
+	return ValidationErrors{{
+		Path:    path,
+		Field:   field,
+		Value:   strconv.FormatInt(count, 10),
+		Rule:    RuleRange,
+		Message: "cannot be negative",
+	}}
+}
@@ -0,0 +1,84 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewTLSReport(t *testing.T) {
+	report, err := NewTLSReport(readTestData(t, "tlsrpt.json"))
+	if err != nil {
+		t.Fatalf("failed to parse TLS report: %s", err)
+	}
+
+	if report.OrganizationName != "Company-X" || report.ReportID != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" {
+		t.Errorf("unexpected report metadata: %+v", report)
+	}
+
+	start := time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC)
+	if !report.DateRange.StartDatetime.Equal(start) {
+		t.Errorf("expected the report to start at %s, got %s", start, report.DateRange.StartDatetime)
+	}
+
+	if len(report.Policies) != 1 {
+		t.Fatalf("expected 1 policy, got %d", len(report.Policies))
+	}
+
+	policy := report.Policies[0]
+	if policy.Policy.PolicyDomain != "company-y.example" || policy.Policy.OriginalPolicyDomain != "Company-Y.example." {
+		t.Errorf("expected the policy domain to be normalized, got %q from %q", policy.Policy.PolicyDomain, policy.Policy.OriginalPolicyDomain)
+	}
+	if len(policy.Policy.PolicyString) != 4 || len(policy.Policy.MXHost) != 1 {
+		t.Errorf("unexpected policy: %+v", policy.Policy)
+	}
+	if policy.Summary != (TLSSummary{TotalSuccessfulSessionCount: 5326, TotalFailureSessionCount: 303}) {
+		t.Errorf("unexpected summary: %+v", policy.Summary)
+	}
+
+	if len(policy.FailureDetails) != 3 {
+		t.Fatalf("expected 3 failure details, got %d", len(policy.FailureDetails))
+	}
+	expected := TLSFailureDetail{
+		ResultType:          "certificate-expired",
+		SendingMTAIP:        "2001:db8:abcd:12::1",
+		ReceivingMXHostname: "mx1.mail.company-y.example",
+		FailedSessionCount:  100,
+	}
+	if policy.FailureDetails[0] != expected {
+		t.Errorf("expected %+v\ngot %+v", expected, policy.FailureDetails[0])
+	}
+
+	if report.ContentHash == "" {
+		t.Error("expected the report to have a content hash")
+	}
+}
+
+func TestNewTLSReportRejects(t *testing.T) {
+	data := readTestData(t, "tlsrpt.json")
+
+	if _, err := NewTLSReport(data[:100]); err == nil {
+		t.Error("expected a truncated report to fail")
+	}
+
+	invalid := bytes.Replace(data, []byte(`"policy-type": "sts"`), []byte(`"policy-type": "dane"`), 1)
+	invalid = bytes.Replace(invalid, []byte(`"198.51.100.62"`), []byte(`"not an ip"`), 1)
+
+	_, err := NewTLSReport(invalid)
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	paths := []string{"policies[0].policy.policy-type", "policies[0].failure-details[2].sending-mta-ip"}
+	if len(errs) != len(paths) {
+		t.Fatalf("expected %d validation errors, got %d: %s", len(paths), len(errs), errs)
+	}
+	for idx, path := range paths {
+		if errs[idx].Path != path {
+			t.Errorf("expected error %d at %s, got %s", idx, path, errs[idx].Path)
+		}
+	}
+}
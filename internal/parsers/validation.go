@@ -0,0 +1,140 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ValidationMode selects how records that fail validation are handled
+type ValidationMode string
+
+const (
+	// ValidationLenient keeps invalid records, tagged with their validation issues
+	ValidationLenient ValidationMode = "lenient"
+	// ValidationStrict rejects the whole report when any record is invalid
+	ValidationStrict ValidationMode = "strict"
+)
+
+// ParseValidationMode parses a validation mode, an empty value is lenient
+func ParseValidationMode(value string) (ValidationMode, error) {
+	switch ValidationMode(value) {
+	case "", ValidationLenient:
+		return ValidationLenient, nil
+	case ValidationStrict:
+		return ValidationStrict, nil
+	}
+
+	return "", fmt.Errorf("validation mode must be one of these values: [%s, %s], got: %s", ValidationLenient, ValidationStrict, value)
+}
+
+// Rules a validated value can break
+const (
+	RuleRequired  = "required"
+	RuleOneOf     = "one_of"
+	RuleRange     = "range"
+	RuleIPAddress = "ip_address"
+	RuleNonZero   = "non_zero"
+)
+
+// ValidationError describes a single value of a report that failed validation
+type ValidationError struct {
+	// Path of the element in the report, e.g. record[3].auth_results.spf[0].scope
+	Path string `json:"path"`
+	// Field is the name of the element
+	Field string `json:"field"`
+	// Value is the value that failed validation, empty if it is missing
+	Value   string `json:"value,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Value == "" {
+		return e.Path + ": " + e.Message
+	}
+
+	return e.Path + ": " + e.Message + ", got: " + e.Value
+}
+
+// validateRequired checks that a value is present
+func validateRequired(field string, value string) error {
+	if value != "" {
+		return nil
+	}
+
+	return &ValidationError{Path: field, Field: field, Rule: RuleRequired, Message: "is required"}
+}
+
+// validateOneOf checks that a value is one of the allowed values,
+// an empty value is only allowed if it is in the allowed values
+func validateOneOf(field string, value string, allowed ...string) error {
+	if slices.Contains(allowed, value) {
+		return nil
+	}
+
+	if value == "" {
+		return validateRequired(field, value)
+	}
+
+	// An empty allowed value marks the field as optional, it is not a value to list
+	values := slices.DeleteFunc(slices.Clone(allowed), func(v string) bool { return v == "" })
+
+	return &ValidationError{
+		Path:    field,
+		Field:   field,
+		Value:   value,
+		Rule:    RuleOneOf,
+		Message: "must be one of these values: [" + strings.Join(values, ", ") + "]",
+	}
+}
+
+// ValidationErrors holds every validation problem found, in document order
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for idx := range e {
+		messages[idx] = e[idx].Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// err returns the ValidationErrors as an error, or nil if there are none
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// add appends the validation problems of err to the list, with their paths
+// prefixed by the path of the parent element, if any. A nil err adds nothing.
+func (e ValidationErrors) add(parent string, err error) ValidationErrors {
+	if err == nil {
+		return e
+	}
+
+	found := ValidationErrors{}
+	validationErr := &ValidationError{}
+	switch {
+	case errors.As(err, &found):
+	case errors.As(err, &validationErr):
+		found = ValidationErrors{*validationErr}
+	default:
+		// Not a validation problem, keep it as is so it is not lost
+		return append(e, ValidationError{Path: parent, Message: err.Error()})
+	}
+
+	for _, validationErr := range found {
+		if parent != "" {
+			validationErr.Path = parent + "." + validationErr.Path
+		}
+		e = append(e, validationErr)
+	}
+
+	return e
+}
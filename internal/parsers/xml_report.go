@@ -1,415 +1,503 @@
 package parsers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+)
+
+// Aggregate report formats
+const (
+	// FormatRFC7489 is the format of RFC 7489, appendix C
+	FormatRFC7489 = "rfc7489"
+	// FormatDMARCbis is the format of the DMARCbis aggregate reporting specification
+	FormatDMARCbis = "dmarcbis"
+)
+
+// Namespaces of the aggregate report formats, reports often have none
+const (
+	NamespaceRFC7489  = "http://dmarc.org/dmarc-xml/0.1"
+	NamespaceDMARCbis = "urn:ietf:params:xml:ns:dmarc-2.0"
 )
 
 type Report struct {
-	Version         string          `xml:"version"`
-	ReportMetadata  ReportMetadata  `xml:"report_metadata"`
-	PolicyPublished PolicyPublished `xml:"policy_published"`
-	Records         []Record        `xml:"record"`
+	Version string `xml:"version" json:"version"`
+	// Format is one of the Format* values, detected while parsing
+	Format          string          `xml:"-" json:"format"`
+	ReportMetadata  ReportMetadata  `xml:"report_metadata" json:"report_metadata"`
+	PolicyPublished PolicyPublished `xml:"policy_published" json:"policy_published"`
+	Records         []Record        `xml:"record" json:"records,omitempty"`
+	// ContentHash is the hex encoded SHA-256 of the raw report
+	ContentHash string `xml:"-" json:"content_hash"`
+	// Conflict is set by the storage when another report of the same reporter
+	// has the same report ID but a different content
+	Conflict bool `xml:"-" json:"conflict,omitempty"`
+	// SchemaIssues are the schema problems of the raw report, kept in schema warn mode
+	SchemaIssues []ValidationError `xml:"-" json:"schema_issues,omitempty"`
+	// Extensions are the unknown elements of the feedback element
+	Extensions []Extension `xml:",any" json:"extensions,omitempty"`
 }
 
 type ReportMetadata struct {
-	OrgName          string    `xml:"org_name"`
-	Email            string    `xml:"email"`
-	ExtraContactInfo string    `xml:"extra_contact_info"`
-	ReportID         string    `xml:"report_id"`
-	DateRange        DateRange `xml:"date_range"`
+	OrgName string `xml:"org_name" json:"org_name"`
+	// Generator is the software that created the report, DMARCbis only
+	Generator        string    `xml:"generator" json:"generator,omitempty"`
+	Email            string    `xml:"email" json:"email"`
+	ExtraContactInfo string    `xml:"extra_contact_info" json:"extra_contact_info"`
+	ReportID         string    `xml:"report_id" json:"report_id"`
+	DateRange        DateRange `xml:"date_range" json:"date_range"`
+	Errors           []string  `xml:"error" json:"errors"`
+	// Extensions are the unknown elements of the report metadata
+	Extensions []Extension `xml:",any" json:"extensions,omitempty"`
 }
 
 type DateRange struct {
-	Begin int64 `xml:"begin"`
-	End   int64 `xml:"end"`
+	Begin int64 `xml:"begin" json:"begin"`
+	End   int64 `xml:"end" json:"end"`
 }
 
 type PolicyPublished struct {
-	Domain                  string `xml:"domain"`
-	AlignmentModeDKIM       string `xml:"adkim"`
-	AlignmentModeSPF        string `xml:"aspf"`
-	Policy                  string `xml:"p"`
-	SubdomainPolicy         string `xml:"sp"`
-	Percentage              int    `xml:"pct"`
-	FailureReportingOptions string `xml:"fo"`
+	Domain string `xml:"domain" json:"domain"`
+	// OriginalDomain is the domain as sent, when it was not in its canonical form
+	OriginalDomain    string `xml:"-" json:"original_domain,omitempty"`
+	AlignmentModeDKIM string `xml:"adkim" json:"adkim"`
+	AlignmentModeSPF  string `xml:"aspf" json:"aspf"`
+	Policy            string `xml:"p" json:"p"`
+	SubdomainPolicy   string `xml:"sp" json:"sp"`
+	// Percentage was removed by DMARCbis, it is nil when not present
+	Percentage              *int   `xml:"pct" json:"pct,omitempty"`
+	FailureReportingOptions string `xml:"fo" json:"fo"`
+	// The following are DMARCbis only
+	// NonexistentSubdomainPolicy is the policy for non-existent subdomains
+	NonexistentSubdomainPolicy string `xml:"np" json:"np,omitempty"`
+	// Testing is y when the policy is in test mode
+	Testing string `xml:"testing" json:"testing,omitempty"`
+	// DiscoveryMethod is how the policy was found, psl or treewalk
+	DiscoveryMethod string `xml:"discovery_method" json:"discovery_method,omitempty"`
 }
 
 type Record struct {
-	Row         Row         `xml:"row"`
-	Identifiers Identifiers `xml:"identifiers"`
-	AuthResults AuthResult  `xml:"auth_results"`
+	Row         Row         `xml:"row" json:"row"`
+	Identifiers Identifiers `xml:"identifiers" json:"identifiers"`
+	AuthResults AuthResult  `xml:"auth_results" json:"auth_results"`
+	// Issues are the validation problems of the record, kept in lenient mode
+	Issues []ValidationError `xml:"-" json:"issues,omitempty"`
+	// Extensions are the unknown elements of the record
+	Extensions []Extension `xml:",any" json:"extensions,omitempty"`
 }
 
 type Row struct {
-	SourceIP        string          `xml:"source_ip"`
-	Count           int             `xml:"count"`
-	PolicyEvaluated PolicyEvaluated `xml:"policy_evaluated"`
+	SourceIP        string          `xml:"source_ip" json:"source_ip"`
+	Count           int             `xml:"count" json:"count"`
+	PolicyEvaluated PolicyEvaluated `xml:"policy_evaluated" json:"policy_evaluated"`
 }
 
 type PolicyEvaluated struct {
-	Disposition string `xml:"disposition"`
-	DKIM        string `xml:"dkim"`
-	SPF         string `xml:"spf"`
+	Disposition string                 `xml:"disposition" json:"disposition"`
+	DKIM        string                 `xml:"dkim" json:"dkim"`
+	SPF         string                 `xml:"spf" json:"spf"`
+	Reasons     []PolicyOverrideReason `xml:"reason" json:"reasons"`
+}
+
+type PolicyOverrideReason struct {
+	Type    string `xml:"type" json:"type"`
+	Comment string `xml:"comment" json:"comment"`
 }
 
 type Identifiers struct {
-	EnvelopeTo   string `xml:"envelope_to"`
-	EnvelopeFrom string `xml:"envelope_from"`
-	HeaderFrom   string `xml:"header_from"`
+	EnvelopeTo   string `xml:"envelope_to" json:"envelope_to"`
+	EnvelopeFrom string `xml:"envelope_from" json:"envelope_from"`
+	HeaderFrom   string `xml:"header_from" json:"header_from"`
+	// The identifiers as sent, when they were not in their canonical form
+	OriginalEnvelopeTo   string `xml:"-" json:"original_envelope_to,omitempty"`
+	OriginalEnvelopeFrom string `xml:"-" json:"original_envelope_from,omitempty"`
+	OriginalHeaderFrom   string `xml:"-" json:"original_header_from,omitempty"`
 }
 
 type AuthResult struct {
-	DKIM DKIMAuthResult `xml:"dkim"`
-	SPF  SPFAuthResult  `xml:"spf"`
+	DKIM []DKIMAuthResult `xml:"dkim" json:"dkim"`
+	SPF  []SPFAuthResult  `xml:"spf" json:"spf"`
 }
 
 type DKIMAuthResult struct {
-	Domain      string `xml:"domain"`
-	Selector    string `xml:"selector"`
-	Result      string `xml:"result"`
-	HumanResult string `xml:"human_result"`
+	Domain string `xml:"domain" json:"domain"`
+	// OriginalDomain is the domain as sent, when it was not in its canonical form
+	OriginalDomain string `xml:"-" json:"original_domain,omitempty"`
+	Selector       string `xml:"selector" json:"selector"`
+	Result         string `xml:"result" json:"result"`
+	HumanResult    string `xml:"human_result" json:"human_result"`
 }
 
 type SPFAuthResult struct {
-	Domain      string `xml:"domain"`
-	Scope       string `xml:"scope"`
-	Result      string `xml:"result"`
-	HumanResult string `xml:"human_result"`
+	Domain string `xml:"domain" json:"domain"`
+	// OriginalDomain is the domain as sent, when it was not in its canonical form
+	OriginalDomain string `xml:"-" json:"original_domain,omitempty"`
+	Scope          string `xml:"scope" json:"scope"`
+	Result         string `xml:"result" json:"result"`
+	HumanResult    string `xml:"human_result" json:"human_result"`
 }
 
-// NewReport creates a new Report from a byte slice (opened file)
+// NewReport creates a new Report from a byte slice (opened file),
+// keeping invalid records tagged with their validation issues
 func NewReport(b []byte) (*Report, error) {
-	report := &Report{}
-	if err := xml.Unmarshal(b, &report); err != nil {
+	return NewReportWithMode(b, ValidationLenient)
+}
+
+// NewReportWithMode creates a new Report from a byte slice (opened file),
+// handling invalid records according to the validation mode
+func NewReportWithMode(b []byte, mode ValidationMode) (*Report, error) {
+	return NewReportWithOptions(b, Options{Validation: mode})
+}
+
+// Options select how a report is checked
+type Options struct {
+	Validation ValidationMode
+	// Schema defaults to SchemaOff
+	Schema SchemaMode
+}
+
+// NewReportWithOptions creates a new Report from a byte slice (opened file),
+// checking it against the schema and validating it according to the options
+func NewReportWithOptions(b []byte, opts Options) (*Report, error) {
+	decoder := newXMLDecoder(bytes.NewReader(b))
+	root, err := rootElement(decoder)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := report.Validate(); err != nil {
+	report := &Report{}
+	if err := decoder.DecodeElement(report, root); err != nil {
 		return nil, err
 	}
+	report.finishExtensions(root.Attr)
 
-	return report, nil
-}
+	hash := sha256.Sum256(b)
+	report.ContentHash = hex.EncodeToString(hash[:])
+	report.Format = report.detectFormat(root.Name.Space)
+	report.Normalize()
 
-// TODO: Check https://www.rfc-editor.org/rfc/rfc7489.html in order to update the validation
-// https://datatracker.ietf.org/doc/html/rfc7489
-func (r *Report) Validate() error {
-	if err := r.PolicyPublished.Validate(); err != nil {
-		return err
-	}
+	// Only the RFC 7489 schema is bundled
+	if report.Format == FormatRFC7489 && (opts.Schema == SchemaWarn || opts.Schema == SchemaError) {
+		err := ValidateSchema(b)
+
+		issues := ValidationErrors{}
+		if err != nil && !errors.As(err, &issues) {
+			return nil, err
+		}
+
+		if len(issues) > 0 && opts.Schema == SchemaError {
+			return nil, issues
+		}
 
-	if err := r.ReportMetadata.Validate(); err != nil {
-		return err
+		if len(issues) > 0 {
+			report.SchemaIssues = issues
+		}
 	}
 
-	for _, record := range r.Records {
-		record.Validate()
+	if err := report.Validate(opts.Validation); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return report, nil
 }
 
-func (r *PolicyPublished) Validate() error {
-	// PolicyPublished is required
-	if r == (&PolicyPublished{}) {
-		return errors.New("policy published is required")
+// detectFormat returns the format of the report from the namespace of the
+// root element, or from the DMARCbis only elements when there is none
+func (r *Report) detectFormat(namespace string) string {
+	switch namespace {
+	case NamespaceDMARCbis:
+		return FormatDMARCbis
+	case NamespaceRFC7489:
+		return FormatRFC7489
 	}
 
-	// Domain is required
-	if r.Domain == "" {
-		return errors.New("policy published - [domain] is required")
+	p := r.PolicyPublished
+	if r.ReportMetadata.Generator != "" || p.NonexistentSubdomainPolicy != "" || p.Testing != "" || p.DiscoveryMethod != "" {
+		return FormatDMARCbis
 	}
 
-	// AlignmentModeDKIM is optional
-	if r.AlignmentModeDKIM != "" {
-		// AlignmentModeDKIM must be one of these values if present
-		if r.AlignmentModeDKIM != "r" && r.AlignmentModeDKIM != "s" {
-			return errors.New("policy published - [alignment mode dkim] must be one of these values: [r, s], got: " + string(r.AlignmentModeDKIM))
+	return FormatRFC7489
+}
+
+// rootElement reads up to the root element of a document
+func rootElement(decoder *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("document has no root element")
+		}
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	// AlignmentModeSPF is optional
-	if r.AlignmentModeSPF != "" {
-		// AlignmentModeSPF must be one of these values if present
-		if r.AlignmentModeSPF != "r" && r.AlignmentModeSPF != "s" {
-			return errors.New("policy published - [alignment mode spf] must be one of these values: [r, s], got: " + string(r.AlignmentModeSPF))
+		if start, ok := token.(xml.StartElement); ok {
+			return &start, nil
 		}
 	}
+}
 
-	// Policy is required
-	if r.Policy == "" {
-		return errors.New("policy published - [policy] is required")
+// InvalidRecords returns the number of records that failed validation
+func (r *Report) InvalidRecords() int {
+	count := 0
+	for _, record := range r.Records {
+		if len(record.Issues) > 0 {
+			count++
+		}
 	}
 
-	// Policy must be one of these values
-	if r.Policy != "none" &&
-		r.Policy != "quarantine" &&
-		r.Policy != "reject" {
-		return errors.New("policy published - [policy] must be one of these values: [none, quarantine, reject], got: " + r.Policy)
-	}
+	return count
+}
 
-	// SubdomainPolicy is optional
-	if r.SubdomainPolicy != "" {
-		// SubdomainPolicy must be one of these values
-		if r.SubdomainPolicy != "none" &&
-			r.SubdomainPolicy != "quarantine" &&
-			r.SubdomainPolicy != "reject" {
-			return errors.New("policy published - [subdomain policy] must be one of these values: [none, quarantine, reject], got: " + r.SubdomainPolicy)
-		}
+// TODO: Check https://www.rfc-editor.org/rfc/rfc7489.html in order to update the validation
+// https://datatracker.ietf.org/doc/html/rfc7489
+//
+// Validate collects every validation problem of the report into ValidationErrors.
+// Invalid records reject the report in strict mode, and are tagged with their issues otherwise.
+func (r *Report) Validate(mode ValidationMode) error {
+	errs := r.validateHeader()
+
+	for idx := range r.Records {
+		errs = append(errs, r.Records[idx].validateAt(idx, mode)...)
 	}
 
-	// Percentage must be between 0 and 100
-	if r.Percentage < 0 || r.Percentage > 100 {
-		return errors.New("policy published - [percentage] must be between 0 and 100, got: " + fmt.Sprintf("%d", r.Percentage))
+	return errs.err()
+}
+
+// validateHeader collects the validation problems of everything but the records
+func (r *Report) validateHeader() ValidationErrors {
+	errs := ValidationErrors{}
+	errs = errs.add("policy_published", r.PolicyPublished.Validate())
+	errs = errs.add("report_metadata", r.ReportMetadata.Validate())
+
+	return errs
+}
+
+// validateAt validates the record found at the given index of its report.
+// In strict mode its problems are returned, otherwise the record is tagged with them.
+func (r *Record) validateAt(idx int, mode ValidationMode) ValidationErrors {
+	issues := ValidationErrors{}.add(fmt.Sprintf("record[%d]", idx), r.Validate())
+
+	if mode == ValidationStrict {
+		return issues
 	}
 
-	// FailureReportingOptions is optional
-	if r.FailureReportingOptions != "" {
-		// FailureReportingOptions must be one of these values
-		if r.FailureReportingOptions != "0" &&
-			r.FailureReportingOptions != "1" &&
-			r.FailureReportingOptions != "d" &&
-			r.FailureReportingOptions != "s" {
-			return errors.New("policy published - [failure reporting options] must be one of these values: [0, 1, d, s], got: " + string(r.FailureReportingOptions))
-		}
+	r.Issues = nil
+	if len(issues) > 0 {
+		r.Issues = issues
 	}
 
 	return nil
 }
 
-func (r *ReportMetadata) Validate() error {
-	// ReportMetadata is required
-	if r == (&ReportMetadata{}) {
-		return errors.New("report metadata is required")
+func (r *PolicyPublished) Validate() error {
+	errs := ValidationErrors{}
+
+	// Domain is required
+	errs = errs.add("", validateRequired("domain", r.Domain))
+
+	// AlignmentModeDKIM is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("adkim", r.AlignmentModeDKIM, "", "r", "s"))
+
+	// AlignmentModeSPF is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("aspf", r.AlignmentModeSPF, "", "r", "s"))
+
+	// Policy is required and must be one of these values
+	errs = errs.add("", validateOneOf("p", r.Policy, "none", "quarantine", "reject"))
+
+	// SubdomainPolicy is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("sp", r.SubdomainPolicy, "", "none", "quarantine", "reject"))
+
+	// Percentage is optional, but must be between 0 and 100 if present
+	if r.Percentage != nil && (*r.Percentage < 0 || *r.Percentage > 100) {
+		errs = append(errs, ValidationError{
+			Path:    "pct",
+			Field:   "pct",
+			Value:   strconv.Itoa(*r.Percentage),
+			Rule:    RuleRange,
+			Message: "must be between 0 and 100",
+		})
 	}
 
+	// FailureReportingOptions is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("fo", r.FailureReportingOptions, "", "0", "1", "d", "s"))
+
+	// NonexistentSubdomainPolicy is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("np", r.NonexistentSubdomainPolicy, "", "none", "quarantine", "reject"))
+
+	// Testing is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("testing", r.Testing, "", "y", "n"))
+
+	// DiscoveryMethod is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("discovery_method", r.DiscoveryMethod, "", "psl", "treewalk"))
+
+	return errs.err()
+}
+
+func (r *ReportMetadata) Validate() error {
+	errs := ValidationErrors{}
+
 	// OrgName is required
-	if r.OrgName == "" {
-		return errors.New("report metadata - [org name] is required")
-	}
+	errs = errs.add("", validateRequired("org_name", r.OrgName))
 
 	// Email is required
-	if r.Email == "" {
-		return errors.New("report metadata - [email] is required")
-	}
+	errs = errs.add("", validateRequired("email", r.Email))
 
 	// ReportID is required
-	if r.ReportID == "" {
-		return errors.New("report metadata - [report id] is required")
-	}
+	errs = errs.add("", validateRequired("report_id", r.ReportID))
 
 	// DateRange is required
-	if err := r.DateRange.Validate(); err != nil {
-		return err
-	}
+	errs = errs.add("date_range", r.DateRange.Validate())
 
-	return nil
+	return errs.err()
 }
 
 func (d *DateRange) Validate() error {
-	// DateRange is required
-	if d == (&DateRange{}) {
-		return errors.New("date range is required")
-	}
+	errs := ValidationErrors{}
 
 	// Begin is required
 	if d.Begin == 0 {
-		return errors.New("date range - [begin] is required")
+		errs = append(errs, ValidationError{Path: "begin", Field: "begin", Rule: RuleRequired, Message: "is required"})
 	}
 
 	// End is required
 	if d.End == 0 {
-		return errors.New("date range - [end] is required")
+		errs = append(errs, ValidationError{Path: "end", Field: "end", Rule: RuleRequired, Message: "is required"})
 	}
 
-	return nil
+	return errs.err()
 }
 
 func (r *Record) Validate() error {
-	if err := r.AuthResults.Validate(); err != nil {
-		return err
-	}
-
-	if err := r.Identifiers.Validate(); err != nil {
-		return err
-	}
+	errs := ValidationErrors{}
+	errs = errs.add("row", r.Row.Validate())
+	errs = errs.add("identifiers", r.Identifiers.Validate())
+	errs = errs.add("auth_results", r.AuthResults.Validate())
 
-	if err := r.Row.Validate(); err != nil {
-		return err
-	}
-
-	return nil
+	return errs.err()
 }
 
 func (a *AuthResult) Validate() error {
-	if err := a.DKIM.Validate(); err != nil {
-		return err
+	errs := ValidationErrors{}
+
+	// DKIM is optional, but every signature present must be valid
+	for idx := range a.DKIM {
+		errs = errs.add(fmt.Sprintf("dkim[%d]", idx), a.DKIM[idx].Validate())
 	}
 
-	if err := a.SPF.Validate(); err != nil {
-		return err
+	// There will always be at least one SPF result
+	if len(a.SPF) == 0 {
+		errs = append(errs, ValidationError{Path: "spf", Field: "spf", Rule: RuleRequired, Message: "is required"})
 	}
 
-	return nil
+	for idx := range a.SPF {
+		errs = errs.add(fmt.Sprintf("spf[%d]", idx), a.SPF[idx].Validate())
+	}
+
+	return errs.err()
 }
 
 func (spf *SPFAuthResult) Validate() error {
-	// SPF is required
-	if spf == (&SPFAuthResult{}) {
-		return errors.New("spf is required")
-	}
+	errs := ValidationErrors{}
 
 	// Domain is required
-	if spf.Domain == "" {
-		return errors.New("spf - [domain] is required")
-	}
+	errs = errs.add("", validateRequired("domain", spf.Domain))
 
-	// Result is required
-	if spf.Result == "" {
-		return errors.New("spf - [result] is required")
-	}
+	// Scope is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("scope", spf.Scope, "", "mfrom", "helo"))
 
-	// Scope is optional
-	if spf.Scope != "" {
-		// Scope must be one of these two values if present
-		if spf.Scope != "mfrom" && spf.Scope != "helo" {
-			return errors.New("spf - [scope] is not empty, so it must be one of these values: [mfrom, helo], got: " + spf.Scope)
-		}
-	}
-
-	// Result must be one of these values
-	if spf.Result != "pass" &&
-		spf.Result != "fail" &&
-		spf.Result != "none" &&
-		spf.Result != "neutral" &&
-		spf.Result != "softfail" &&
-		spf.Result != "permerror" &&
-		spf.Result != "temperror" {
-		return errors.New("spf - [result] must be one of these values: [pass, fail, none, neutral, softfail, permerror, temperror], got: " + spf.Result)
-	}
+	// Result is required and must be one of these values
+	errs = errs.add("", validateOneOf("result", spf.Result, "pass", "fail", "none", "neutral", "softfail", "permerror", "temperror"))
 
-	return nil
+	return errs.err()
 }
 
 func (dkim *DKIMAuthResult) Validate() error {
-	// DKIM is optional
-	if dkim == (&DKIMAuthResult{}) {
+	// An empty DKIM result is the same as no result
+	if *dkim == (DKIMAuthResult{}) {
 		return nil
 	}
 
-	// If DKIM is not nil, then it must be valid
+	errs := ValidationErrors{}
+
+	// If DKIM is not empty, then it must be valid
 	// Domain is required
-	if dkim.Domain == "" {
-		return errors.New("dkim - [domain] is required")
-	}
+	errs = errs.add("", validateRequired("domain", dkim.Domain))
 
 	// Selector is required
-	if dkim.Selector == "" {
-		return errors.New("dkim - [selector] is required")
-	}
+	errs = errs.add("", validateRequired("selector", dkim.Selector))
 
-	// Result is required
-	if dkim.Result == "" {
-		return errors.New("dkim - [result] is required")
-	}
+	// Result is required and must be one of these values
+	errs = errs.add("", validateOneOf("result", dkim.Result, "pass", "fail", "none", "neutral", "policy", "permerror", "temperror"))
 
-	// Result must be one of these values
-	if dkim.Result != "pass" &&
-		dkim.Result != "fail" &&
-		dkim.Result != "none" &&
-		dkim.Result != "neutral" &&
-		dkim.Result != "policy" &&
-		dkim.Result != "permerror" &&
-		dkim.Result != "temperror" {
-		return errors.New("dkim - [result] must be one of these values: [pass, fail, none, neutral, policy, permerror, temperror], got: " + dkim.Result)
-	}
-
-	return nil
+	return errs.err()
 }
 
 func (i *Identifiers) Validate() error {
-	// Identifiers is required
-	if i == (&Identifiers{}) {
-		return errors.New("identifiers is required")
-	}
+	errs := ValidationErrors{}
 
 	// EnvelopeFrom is required
-	if i.EnvelopeFrom == "" {
-		return errors.New("identifiers - [envelope from] is required")
-	}
+	errs = errs.add("", validateRequired("envelope_from", i.EnvelopeFrom))
 
 	// HeaderFrom is required
-	if i.HeaderFrom == "" {
-		return errors.New("identifiers - [header from] is required")
-	}
+	errs = errs.add("", validateRequired("header_from", i.HeaderFrom))
 
-	return nil
+	return errs.err()
 }
 
 func (r *Row) Validate() error {
-	// Row is required
-	if r == (&Row{}) {
-		return errors.New("row is required")
-	}
+	errs := ValidationErrors{}
 
-	// SourceIP is required
+	// SourceIP is required, and must be an IP address if present
 	if r.SourceIP == "" {
-		return errors.New("row - [source ip] is required")
-	}
-
-	if net.ParseIP(r.SourceIP) == nil {
-		return errors.New("row - [source ip] is not a valid IP address")
+		errs = errs.add("", validateRequired("source_ip", r.SourceIP))
+	} else if net.ParseIP(r.SourceIP) == nil {
+		errs = append(errs, ValidationError{
+			Path:    "source_ip",
+			Field:   "source_ip",
+			Value:   r.SourceIP,
+			Rule:    RuleIPAddress,
+			Message: "is not a valid IP address",
+		})
 	}
 
 	// Count is required
 	if r.Count == 0 {
-		return errors.New("row - [count] cannot be 0")
+		errs = append(errs, ValidationError{Path: "count", Field: "count", Value: "0", Rule: RuleNonZero, Message: "cannot be 0"})
 	}
 
-	if err := r.PolicyEvaluated.Validate(); err != nil {
-		return err
-	}
+	errs = errs.add("policy_evaluated", r.PolicyEvaluated.Validate())
 
-	return nil
+	return errs.err()
 }
 
 func (p *PolicyEvaluated) Validate() error {
-	// PolicyEvaluated is required
-	if p == (&PolicyEvaluated{}) {
-		return errors.New("policy evaluated is required")
-	}
+	errs := ValidationErrors{}
 
-	// Disposition is required
-	if p.Disposition == "" {
-		return errors.New("policy evaluated - [disposition] is required")
-	}
+	// Disposition is required and must be one of these values
+	errs = errs.add("", validateOneOf("disposition", p.Disposition, "none", "quarantine", "reject"))
 
-	// Disposition must be one of these values
-	if p.Disposition != "none" &&
-		p.Disposition != "reject" &&
-		p.Disposition != "quarantine" {
-		return errors.New("policy evaluated - [disposition] must be one of these values: [none, quarantine, reject], got: " + p.Disposition)
-	}
+	// DKIM is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("dkim", p.DKIM, "", "pass", "fail"))
 
-	// DKIM is optional
-	if p.DKIM != "" {
-		// DKIM must be one of these values
-		if p.DKIM != "pass" &&
-			p.DKIM != "fail" {
-			return errors.New("policy evaluated - [dkim] must be one of these values: [pass, fail], got: " + p.DKIM)
-		}
-	}
+	// SPF is optional, but must be one of these values if present
+	errs = errs.add("", validateOneOf("spf", p.SPF, "", "pass", "fail"))
 
-	// SPF is optional
-	if p.SPF != "" {
-		if p.SPF != "pass" &&
-			p.SPF != "fail" {
-			return errors.New("policy evaluated - [spf] must be one of these values: [pass, fail], got: " + p.SPF)
-		}
+	// Reasons are optional, but every reason present must be valid
+	for idx := range p.Reasons {
+		errs = errs.add(fmt.Sprintf("reason[%d]", idx), p.Reasons[idx].Validate())
 	}
 
-	return nil
+	return errs.err()
+}
+
+func (o *PolicyOverrideReason) Validate() error {
+	// Type is required and must be one of these values
+	// Comment is optional
+	return validateOneOf("type", o.Type, "forwarded", "sampled_out", "trusted_forwarder", "mailing_list", "local_policy", "other")
 }
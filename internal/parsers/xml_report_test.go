@@ -0,0 +1,299 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", name, err)
+	}
+
+	return data
+}
+
+var invalidScope = ValidationError{
+	Path:    "record[1].auth_results.spf[0].scope",
+	Field:   "scope",
+	Value:   "envelope",
+	Rule:    RuleOneOf,
+	Message: "must be one of these values: [mfrom, helo]",
+}
+
+func TestNewReportLenient(t *testing.T) {
+	report, err := NewReportWithMode(readTestData(t, "invalid_record.xml"), ValidationLenient)
+	if err != nil {
+		t.Fatalf("expected the report to be kept, got %s", err)
+	}
+
+	if len(report.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(report.Records))
+	}
+
+	if len(report.Records[0].Issues) != 0 {
+		t.Errorf("expected the first record to be valid, got %v", report.Records[0].Issues)
+	}
+
+	if len(report.Records[1].Issues) != 1 || report.Records[1].Issues[0] != invalidScope {
+		t.Errorf("expected the second record to be tagged with %+v, got %+v", invalidScope, report.Records[1].Issues)
+	}
+
+	if report.InvalidRecords() != 1 {
+		t.Errorf("expected 1 invalid record, got %d", report.InvalidRecords())
+	}
+}
+
+func TestNewReportStrict(t *testing.T) {
+	_, err := NewReportWithMode(readTestData(t, "invalid_record.xml"), ValidationStrict)
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	if len(errs) != 1 || errs[0] != invalidScope {
+		t.Errorf("expected %+v, got %+v", invalidScope, errs)
+	}
+
+	if _, err := NewReportWithMode(readTestData(t, "extensions.xml"), ValidationStrict); err != nil {
+		t.Errorf("expected a valid report to be accepted, got %s", err)
+	}
+}
+
+func TestReportValidateRejectsMetadata(t *testing.T) {
+	// Problems outside of the records reject the report in both modes
+	for _, mode := range []ValidationMode{ValidationLenient, ValidationStrict} {
+		report := &Report{
+			PolicyPublished: PolicyPublished{Domain: "example.com", Policy: "none"},
+			ReportMetadata:  ReportMetadata{OrgName: "example.net", Email: "dmarc@example.net", ReportID: "1"},
+		}
+
+		err := report.Validate(mode)
+
+		errs := ValidationErrors{}
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: expected validation errors, got %v", mode, err)
+		}
+
+		if len(errs) != 2 || errs[0].Path != "report_metadata.date_range.begin" || errs[1].Path != "report_metadata.date_range.end" {
+			t.Errorf("%s: expected a required date range begin and end, got %+v", mode, errs)
+		}
+	}
+}
+
+func TestReportValidateCollectsAll(t *testing.T) {
+	percentage := 101
+	report := &Report{
+		PolicyPublished: PolicyPublished{Domain: "example.com", AlignmentModeDKIM: "x", Policy: "block", Percentage: &percentage},
+		ReportMetadata:  ReportMetadata{OrgName: "example.net", ReportID: "1", DateRange: DateRange{Begin: 1, End: 2}},
+		Records: []Record{
+			{
+				Row: Row{
+					SourceIP:        "192.0.2.1",
+					Count:           1,
+					PolicyEvaluated: PolicyEvaluated{Disposition: "none", DKIM: "pass", SPF: "pass"},
+				},
+				Identifiers: Identifiers{EnvelopeFrom: "example.com", HeaderFrom: "example.com"},
+				AuthResults: AuthResult{SPF: []SPFAuthResult{{Domain: "example.com", Result: "pass"}}},
+			},
+			{
+				Row: Row{
+					SourceIP:        "192.0.2.256",
+					PolicyEvaluated: PolicyEvaluated{Disposition: "none", Reasons: []PolicyOverrideReason{{Type: "unknown"}}},
+				},
+				Identifiers: Identifiers{EnvelopeFrom: "example.com", HeaderFrom: "example.com"},
+				AuthResults: AuthResult{
+					DKIM: []DKIMAuthResult{{Domain: "example.com", Selector: "s1", Result: "ok"}},
+					SPF:  []SPFAuthResult{{Domain: "example.com", Scope: "envelope", Result: "pass"}},
+				},
+			},
+		},
+	}
+
+	err := report.Validate(ValidationStrict)
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		"policy_published.adkim",
+		"policy_published.p",
+		"policy_published.pct",
+		"report_metadata.email",
+		"record[1].row.source_ip",
+		"record[1].row.count",
+		"record[1].row.policy_evaluated.reason[0].type",
+		"record[1].auth_results.dkim[0].result",
+		"record[1].auth_results.spf[0].scope",
+	}
+
+	paths := make([]string, len(errs))
+	for idx, validationErr := range errs {
+		paths[idx] = validationErr.Path
+	}
+
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected the problems at\n%v\ngot\n%v", expected, paths)
+	}
+
+	// In lenient mode, only the problems outside of the records are returned
+	err = report.Validate(ValidationLenient)
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Errorf("expected 4 validation errors, got %v", err)
+	}
+
+	if len(report.Records[0].Issues) != 0 || len(report.Records[1].Issues) != 5 {
+		t.Errorf("expected the second record to be tagged with 5 issues, got %d and %d", len(report.Records[0].Issues), len(report.Records[1].Issues))
+	}
+}
+
+func TestNewReportDMARCbis(t *testing.T) {
+	report, err := NewReportWithMode(readTestData(t, "dmarcbis.xml"), ValidationStrict)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	if report.Format != FormatDMARCbis {
+		t.Errorf("expected format %s, got %s", FormatDMARCbis, report.Format)
+	}
+
+	if report.ReportMetadata.Generator != "Example Reporter 2.1" {
+		t.Errorf("expected the generator to be parsed, got %q", report.ReportMetadata.Generator)
+	}
+
+	expected := PolicyPublished{
+		Domain:                     "example.com",
+		AlignmentModeDKIM:          "r",
+		AlignmentModeSPF:           "s",
+		Policy:                     "quarantine",
+		SubdomainPolicy:            "quarantine",
+		FailureReportingOptions:    "1",
+		NonexistentSubdomainPolicy: "reject",
+		Testing:                    "n",
+		DiscoveryMethod:            "treewalk",
+	}
+	if report.PolicyPublished != expected {
+		t.Errorf("expected policy published %+v, got %+v", expected, report.PolicyPublished)
+	}
+}
+
+func TestNewReportFormat(t *testing.T) {
+	report, err := NewReport(readTestData(t, "valid5.xml"))
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	if report.Format != FormatRFC7489 {
+		t.Errorf("expected format %s, got %s", FormatRFC7489, report.Format)
+	}
+
+	if report.PolicyPublished.Percentage == nil || *report.PolicyPublished.Percentage != 100 {
+		t.Errorf("expected pct 100, got %v", report.PolicyPublished.Percentage)
+	}
+
+	// Without a namespace, the DMARCbis only elements give the format away
+	data := strings.Replace(string(readTestData(t, "dmarcbis.xml")), ` xmlns="urn:ietf:params:xml:ns:dmarc-2.0"`, "", 1)
+	report, err = NewReport([]byte(data))
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	if report.Format != FormatDMARCbis {
+		t.Errorf("expected format %s, got %s", FormatDMARCbis, report.Format)
+	}
+}
+
+func TestNewReportExtensions(t *testing.T) {
+	report, err := NewReportWithMode(readTestData(t, "extensions.xml"), ValidationStrict)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+
+	expected := []Extension{{
+		Name: "extension",
+		XML:  "<extension xmlns:vendor=\"urn:example:vendor\">\n    <vendor:report_stats>\n      <vendor:messages>7</vendor:messages>\n    </vendor:report_stats>\n  </extension>",
+	}}
+	if !slices.Equal(report.Extensions, expected) {
+		t.Errorf("expected the feedback extensions %+v, got %+v", expected, report.Extensions)
+	}
+
+	expected = []Extension{{
+		Name: "contact_form",
+		XML:  `<vendor:contact_form xmlns:vendor="urn:example:vendor" href="https://mail.example.org/dmarc">DMARC support</vendor:contact_form>`,
+	}}
+	if !slices.Equal(report.ReportMetadata.Extensions, expected) {
+		t.Errorf("expected the report metadata extensions %+v, got %+v", expected, report.ReportMetadata.Extensions)
+	}
+
+	expected = []Extension{{
+		Name: "envelope_info",
+		XML:  "<vendor:envelope_info xmlns:vendor=\"urn:example:vendor\">\n      <vendor:rcpt_domain>example.net</vendor:rcpt_domain>\n    </vendor:envelope_info>",
+	}}
+	if len(report.Records) != 1 || !slices.Equal(report.Records[0].Extensions, expected) {
+		t.Errorf("expected the record extensions %+v, got %+v", expected, report.Records)
+	}
+}
+
+func TestNewReportExtensionNamespaces(t *testing.T) {
+	data := bytes.Replace(readTestData(t, "extensions.xml"), []byte("<record>"), []byte(`<record xmlns:rec="urn:example:record">`), 1)
+	data = bytes.Replace(data, []byte("</record>"), []byte(`  <rec:note xml:lang="en" vendor:source="mta">Forwarded</rec:note>
+    <vendor:relay xmlns:vendor="urn:example:relay" xmlns="urn:example:default"><host/></vendor:relay>
+    <plain xmlns="urn:example:default"/>
+  </record>`), 1)
+
+	// The namespaces of the record and the root are both in scope, the
+	// declarations of an element take precedence over the inherited ones
+	expected := []Extension{{
+		Name: "envelope_info",
+		XML:  "<vendor:envelope_info xmlns:vendor=\"urn:example:vendor\" xmlns:rec=\"urn:example:record\">\n      <vendor:rcpt_domain>example.net</vendor:rcpt_domain>\n    </vendor:envelope_info>",
+	}, {
+		Name: "note",
+		XML:  `<rec:note xmlns:vendor="urn:example:vendor" xmlns:rec="urn:example:record" xml:lang="en" vendor:source="mta">Forwarded</rec:note>`,
+	}, {
+		Name: "relay",
+		XML:  `<vendor:relay xmlns:rec="urn:example:record" xmlns:vendor="urn:example:relay" xmlns="urn:example:default"><host/></vendor:relay>`,
+	}, {
+		Name: "plain",
+		XML:  `<plain xmlns:vendor="urn:example:vendor" xmlns:rec="urn:example:record" xmlns="urn:example:default"/>`,
+	}}
+
+	report, err := NewReport(data)
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+	if len(report.Records) != 1 || !slices.Equal(report.Records[0].Extensions, expected) {
+		t.Errorf("expected the record extensions %+v, got %+v", expected, report.Records)
+	}
+
+	streamed, err := decodeAll(t, data, ValidationLenient, 10)
+	if err != nil {
+		t.Fatalf("failed to decode report: %s", err)
+	}
+	if len(streamed.Records) != 1 || !slices.Equal(streamed.Records[0].Extensions, expected) {
+		t.Errorf("expected the streamed record extensions %+v, got %+v", expected, streamed.Records)
+	}
+
+	// Every fragment can be parsed on its own, with its element in the same namespace
+	spaces := []string{"urn:example:vendor", "urn:example:record", "urn:example:relay", "urn:example:default"}
+	for idx, extension := range report.Records[0].Extensions {
+		element := struct{ XMLName xml.Name }{}
+		if err := xml.Unmarshal([]byte(extension.XML), &element); err != nil {
+			t.Errorf("failed to parse extension %s: %s", extension.Name, err)
+		}
+		if element.XMLName.Space != spaces[idx] {
+			t.Errorf("expected extension %s in namespace %s, got %s", extension.Name, spaces[idx], element.XMLName.Space)
+		}
+	}
+}
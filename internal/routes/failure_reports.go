@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+// HandleListFailureReports returns the stored failure reports
+//
+// Query parameters:
+//   - domain: only the failure reports about this domain
+func HandleListFailureReports(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		reports, err := store.FindFailureReports(c.Query("domain"))
+		if err != nil {
+			log.Errorf("Failed to find failure reports: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to find failure reports"})
+		}
+
+		return c.JSON(&types.FailureReportListResponse{FailureReports: reports})
+	}
+}
+
+// HandleGetFailureReport returns a single failure report, by its content hash
+func HandleGetFailureReport(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, err := store.FindFailureReportByHash(c.Params("hash"))
+		if err != nil {
+			if errors.Is(err, database.ErrReportNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			log.Errorf("Failed to find failure report %s: %s", c.Params("hash"), err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to find failure report"})
+		}
+
+		return c.JSON(report)
+	}
+}
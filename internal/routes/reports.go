@@ -0,0 +1,409 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/attachments"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+// HandleCreateReports accepts reports as a raw xml, json, gzip or zip body,
+// or as one or more files of a multipart form. Failure reports are accepted
+// as the message they were sent in, e.g. an .eml file. Each report has its own result,
+// the status tells whether all, some or none of them were accepted.
+//
+// Query parameters:
+//   - validation: lenient (default) keeps invalid records tagged with their issues,
+//     strict rejects reports with invalid records
+//   - schema: off (default), warn keeps reports that do not conform to the schema
+//     with their schema issues, error rejects them
+func HandleCreateReports(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		results := []types.ReportUploadResult{}
+
+		validation, err := parsers.ParseValidationMode(c.Query("validation"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid validation: " + err.Error()})
+		}
+
+		schema, err := parsers.ParseSchemaMode(c.Query("schema"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid schema: " + err.Error()})
+		}
+
+		opts := parsers.Options{Validation: validation, Schema: schema}
+
+		if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+			form, err := c.MultipartForm()
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			for _, files := range form.File {
+				for _, file := range files {
+					results = append(results, storeUploadedFile(store, opts, file)...)
+				}
+			}
+		} else if attachments.Detect(c.Body()) == attachments.FormatMessage {
+			results = storeUploadedMessage(store, opts, "", c.Body())
+		} else {
+			payloads, err := attachments.Extract("", c.Body(), attachments.DefaultLimits)
+			if err != nil {
+				return c.Status(uploadErrorStatus(err)).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			for _, payload := range payloads {
+				results = append(results, storeUploadedReport(store, opts, payload))
+			}
+		}
+
+		if len(results) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "no report was uploaded"})
+		}
+
+		return c.Status(uploadStatus(results)).JSON(&types.ReportUploadResponse{Results: results})
+	}
+}
+
+// uploadStatus is 200 when every report was accepted, 207 when only some were,
+// and otherwise 500 if the storage failed or 422 if every report was invalid
+func uploadStatus(results []types.ReportUploadResult) int {
+	accepted, failed := 0, 0
+	for _, result := range results {
+		switch result.Status {
+		case types.ReportStatusCreated, types.ReportStatusDuplicate, types.ReportStatusConflict:
+			accepted++
+		case types.ReportStatusFailed:
+			failed++
+		}
+	}
+
+	switch {
+	case accepted == len(results):
+		return fiber.StatusOK
+	case accepted > 0:
+		return fiber.StatusMultiStatus
+	case failed > 0:
+		return fiber.StatusInternalServerError
+	}
+
+	return fiber.StatusUnprocessableEntity
+}
+
+// storeUploadedFile stores every report of a multipart file
+func storeUploadedFile(store database.Storage, opts parsers.Options, file *multipart.FileHeader) []types.ReportUploadResult {
+	invalid := func(err error) []types.ReportUploadResult {
+		return []types.ReportUploadResult{{Name: file.Filename, Status: types.ReportStatusInvalid, Error: err.Error()}}
+	}
+
+	if file.Size > attachments.DefaultLimits.MaxDecompressedSize {
+		return invalid(attachments.ErrTooLarge)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return invalid(err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return invalid(err)
+	}
+
+	if attachments.Detect(data) == attachments.FormatMessage {
+		return storeUploadedMessage(store, opts, file.Filename, data)
+	}
+
+	payloads, err := attachments.Extract(file.Filename, data, attachments.DefaultLimits)
+	if err != nil {
+		return invalid(err)
+	}
+
+	results := make([]types.ReportUploadResult, len(payloads))
+	for idx, payload := range payloads {
+		results[idx] = storeUploadedReport(store, opts, payload)
+	}
+
+	return results
+}
+
+// storeUploadedMessage stores every report of an RFC 5322 message,
+// the attached aggregate reports and the failure reports
+func storeUploadedMessage(store database.Storage, opts parsers.Options, name string, data []byte) []types.ReportUploadResult {
+	reports, err := attachments.FromMessage(bytes.NewReader(data), attachments.DefaultLimits)
+	if err != nil {
+		return []types.ReportUploadResult{{Name: name, Status: types.ReportStatusInvalid, Error: err.Error()}}
+	}
+
+	results := []types.ReportUploadResult{}
+	for _, attachment := range reports.Payloads {
+		payloads, err := attachments.Extract(attachment.Name, attachment.Data, attachments.DefaultLimits)
+		if err != nil {
+			results = append(results, types.ReportUploadResult{Name: attachment.Name, Status: types.ReportStatusInvalid, Error: err.Error()})
+			continue
+		}
+
+		for _, payload := range payloads {
+			results = append(results, storeUploadedReport(store, opts, payload))
+		}
+	}
+
+	for _, failure := range reports.Failures {
+		results = append(results, storeUploadedFailureReport(store, name, failure))
+	}
+
+	return results
+}
+
+// storeUploadedFailureReport parses and stores a single failure report
+func storeUploadedFailureReport(store database.Storage, name string, failure attachments.FailurePayload) types.ReportUploadResult {
+	result := types.ReportUploadResult{Name: name, Type: types.ReportTypeFailure}
+
+	report, err := parsers.NewFailureReport(failure.Feedback, failure.Original)
+	if err != nil {
+		result.Status = types.ReportStatusInvalid
+		result.Error = err.Error()
+		errors.As(err, &result.ValidationErrors)
+		return result
+	}
+
+	result.ContentHash = report.ContentHash
+
+	err = store.CreateFailureReport(report)
+	switch {
+	case err == nil:
+		result.Status = types.ReportStatusCreated
+	case errors.Is(err, database.ErrDuplicateReport):
+		result.Status = types.ReportStatusDuplicate
+	default:
+		log.Errorf("Failed to save failure report %s: %s", result.ContentHash, err)
+		result.Status = types.ReportStatusFailed
+		result.Error = "failed to store report"
+	}
+
+	return result
+}
+
+// storeUploadedTLSReport parses and stores a single decompressed TLS report
+func storeUploadedTLSReport(store database.Storage, payload attachments.Payload) types.ReportUploadResult {
+	result := types.ReportUploadResult{Name: payload.Name, Type: types.ReportTypeTLS}
+
+	report, err := parsers.NewTLSReport(payload.Data)
+	if err != nil {
+		result.Status = types.ReportStatusInvalid
+		result.Error = err.Error()
+		errors.As(err, &result.ValidationErrors)
+		return result
+	}
+
+	result.ReportID = report.ReportID
+	result.OrgName = report.OrganizationName
+	result.ContentHash = report.ContentHash
+
+	err = store.CreateTLSReport(report)
+	switch {
+	case err == nil:
+		result.Status = types.ReportStatusCreated
+	case errors.Is(err, database.ErrDuplicateReport):
+		result.Status = types.ReportStatusDuplicate
+	default:
+		log.Errorf("Failed to save TLS report %s: %s", result.ReportID, err)
+		result.Status = types.ReportStatusFailed
+		result.Error = "failed to store report"
+	}
+
+	return result
+}
+
+// storeUploadedReport parses and stores a single decompressed report, aggregate or TLS
+func storeUploadedReport(store database.Storage, opts parsers.Options, payload attachments.Payload) types.ReportUploadResult {
+	if attachments.Detect(payload.Data) == attachments.FormatJSON {
+		return storeUploadedTLSReport(store, payload)
+	}
+
+	result := types.ReportUploadResult{Name: payload.Name, Type: types.ReportTypeAggregate}
+
+	report, err := parsers.NewReportWithOptions(payload.Data, opts)
+	if err != nil {
+		result.Status = types.ReportStatusInvalid
+		result.Error = err.Error()
+
+		// Every problem is listed, so a broken report can be fixed in one go
+		errors.As(err, &result.ValidationErrors)
+		return result
+	}
+
+	result.ReportID = report.ReportMetadata.ReportID
+	result.OrgName = report.ReportMetadata.OrgName
+	result.ContentHash = report.ContentHash
+	result.Records = len(report.Records)
+	result.InvalidRecords = report.InvalidRecords()
+	result.SchemaIssues = len(report.SchemaIssues)
+
+	err = store.CreateReport(report)
+	switch {
+	case err == nil:
+		result.Status = types.ReportStatusCreated
+	case errors.Is(err, database.ErrDuplicateReport):
+		result.Status = types.ReportStatusDuplicate
+	case errors.Is(err, database.ErrConflictingReport):
+		result.Status = types.ReportStatusConflict
+	default:
+		log.Errorf("Failed to save report %s: %s", result.ReportID, err)
+		result.Status = types.ReportStatusFailed
+		result.Error = "failed to store report"
+	}
+
+	return result
+}
+
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, attachments.ErrTooLarge), errors.Is(err, attachments.ErrTooManyEntries):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, attachments.ErrUnknownFormat):
+		return fiber.StatusUnsupportedMediaType
+	}
+
+	return fiber.StatusBadRequest
+}
+
+// HandleListReports lists reports, without their records
+//
+// Query parameters:
+//   - domain, org_name, report_id: exact match on the policy domain, reporter and report ID
+//   - from, to: date range overlap, as unix seconds or RFC 3339
+//   - has_failures: true or false, reports with records that failed DMARC
+//   - conflicting: true to list only reports that conflict with another report
+//   - sort: date_range_begin (default), date_range_end, org_name or domain
+//   - order: asc or desc (default)
+//   - limit: page size, up to 500
+//   - cursor: next_cursor of the previous page
+func HandleListReports(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := database.ReportQuery{
+			Domain:     c.Query("domain"),
+			OrgName:    c.Query("org_name"),
+			ReportID:   c.Query("report_id"),
+			Sort:       c.Query("sort"),
+			Descending: c.Query("order", "desc") == "desc",
+			Cursor:     c.Query("cursor"),
+			Limit:      c.QueryInt("limit"),
+		}
+
+		var err error
+		if query.From, err = parseTimeQuery(c.Query("from")); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid from: " + err.Error()})
+		}
+
+		if query.To, err = parseTimeQuery(c.Query("to")); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid to: " + err.Error()})
+		}
+
+		if value := c.Query("has_failures"); value != "" {
+			hasFailures, err := strconv.ParseBool(value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid has_failures: " + err.Error()})
+			}
+			query.HasFailures = &hasFailures
+		}
+
+		if value := c.Query("conflicting"); value != "" {
+			if query.Conflicting, err = strconv.ParseBool(value); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: "invalid conflicting: " + err.Error()})
+			}
+		}
+
+		page, err := store.QueryReports(query)
+		if err != nil {
+			if errors.Is(err, database.ErrInvalidQuery) {
+				return c.Status(fiber.StatusBadRequest).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			log.Errorf("Failed to query reports: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to query reports"})
+		}
+
+		return c.JSON(&types.ReportListResponse{
+			Reports:    page.Reports,
+			NextCursor: page.NextCursor,
+		})
+	}
+}
+
+// HandleGetReport returns a single report with its records, by its content hash
+// or by its report ID. Report IDs are only unique per reporter, and conflicting
+// reports share them, so a report ID matching several reports is answered with
+// 409 Conflict. Reports stored before content hashes were recorded have none,
+// and can only be found by their report ID.
+//
+// Query parameters:
+//   - org_name: the reporter, to tell apart reports of several reporters with the same report ID
+func HandleGetReport(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		report, err := store.FindReportByHash(id)
+		if errors.Is(err, database.ErrReportNotFound) {
+			report, err = findReportByReportID(store, id, c.Query("org_name"))
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrReportNotFound):
+				return c.Status(fiber.StatusNotFound).JSON(&types.ErrorResponse{Error: err.Error()})
+			case errors.Is(err, errAmbiguousReportID):
+				return c.Status(fiber.StatusConflict).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			log.Errorf("Failed to find report %s: %s", id, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to find report"})
+		}
+
+		return c.JSON(report)
+	}
+}
+
+var errAmbiguousReportID = errors.New("report ID matches several reports, use the content hash or org_name")
+
+// findReportByReportID returns the single report with the given report ID,
+// and reporter if not empty
+func findReportByReportID(store database.Storage, reportID string, orgName string) (*parsers.Report, error) {
+	page, err := store.QueryReports(database.ReportQuery{ReportID: reportID, OrgName: orgName, Limit: 2})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(page.Reports) {
+	case 0:
+		return nil, database.ErrReportNotFound
+	case 1:
+		metadata := page.Reports[0].ReportMetadata
+		return store.FindReportByReportID(metadata.OrgName, metadata.ReportID)
+	}
+
+	return nil, errAmbiguousReportID
+}
+
+// parseTimeQuery parses unix seconds or an RFC 3339 time, an empty value is the zero time
+func parseTimeQuery(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,458 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	database_sqlite "github.com/stavros-k/go-dmarc-analyzer/internal/database/sqlite"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+// newTestStorage creates a migrated sqlite storage in a temporary directory
+func newTestStorage(t *testing.T) *database_sqlite.SqliteStorage {
+	t.Helper()
+
+	store, err := database_sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "dmarc.db"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %s", err)
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate storage: %s", err)
+	}
+
+	return store
+}
+
+// newTestApp registers the report routes like the API server does
+func newTestApp(store database.Storage) *fiber.App {
+	app := fiber.New()
+
+	api := app.Group("/api/v1")
+	api.Get("/reports", HandleListReports(store))
+	api.Get("/reports/:id", HandleGetReport(store))
+	api.Post("/reports", HandleCreateReports(store))
+
+	return app
+}
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", name, err)
+	}
+
+	return data
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("failed to gzip: %s", err)
+	}
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+// doRequest sends a request to the app and decodes the JSON response into v
+func doRequest(t *testing.T, app *fiber.App, req *http.Request, v any) int {
+	t.Helper()
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("failed to decode response %q: %s", body, err)
+	}
+
+	return resp.StatusCode
+}
+
+// uploadRequest builds a multipart upload with a file per name and content pair
+func uploadRequest(t *testing.T, files ...[]byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for idx := 0; idx < len(files); idx += 2 {
+		part, err := writer.CreateFormFile("reports", string(files[idx]))
+		if err != nil {
+			t.Fatalf("failed to create form file: %s", err)
+		}
+		part.Write(files[idx+1])
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/v1/reports", &body)
+	req.Header.Set(fiber.HeaderContentType, writer.FormDataContentType())
+
+	return req
+}
+
+// failingStorage fails every report insert, like a locked or full database
+type failingStorage struct {
+	database.Storage
+}
+
+func (f *failingStorage) CreateReport(*parsers.Report) error {
+	return errors.New("database is locked")
+}
+
+func TestHandleCreateReportsRaw(t *testing.T) {
+	app := newTestApp(newTestStorage(t))
+
+	// A resend is accepted as a duplicate
+	for _, expected := range []string{types.ReportStatusCreated, types.ReportStatusDuplicate} {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/v1/reports", bytes.NewReader(gzipData(t, readTestData(t, "valid1.xml"))))
+
+		response := types.ReportUploadResponse{}
+		if status := doRequest(t, app, req, &response); status != fiber.StatusOK {
+			t.Errorf("expected status %d, got %d", fiber.StatusOK, status)
+		}
+
+		if len(response.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(response.Results))
+		}
+		result := response.Results[0]
+		if result.Status != expected || result.ReportID != "valid1reportid" || result.ContentHash == "" {
+			t.Errorf("expected a %s result for valid1reportid, got %+v", expected, result)
+		}
+	}
+}
+
+func TestHandleCreateReportsMultipart(t *testing.T) {
+	store := newTestStorage(t)
+	app := newTestApp(store)
+
+	req := uploadRequest(t,
+		[]byte("valid2.xml"), readTestData(t, "valid2.xml"),
+		[]byte("valid3.xml.gz"), gzipData(t, readTestData(t, "valid3.xml")),
+	)
+
+	response := types.ReportUploadResponse{}
+	if status := doRequest(t, app, req, &response); status != fiber.StatusOK {
+		t.Errorf("expected status %d, got %d", fiber.StatusOK, status)
+	}
+
+	if len(response.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(response.Results))
+	}
+	for _, result := range response.Results {
+		if result.Status != types.ReportStatusCreated || result.Records == 0 {
+			t.Errorf("expected the report to be created with its records, got %+v", result)
+		}
+
+		if _, err := store.FindReportByHash(result.ContentHash); err != nil {
+			t.Errorf("expected report %s to be stored: %s", result.ReportID, err)
+		}
+	}
+}
+
+func TestHandleCreateReportsFailureReport(t *testing.T) {
+	store := newTestStorage(t)
+	app := newTestApp(store)
+	message := readTestData(t, "failure.eml")
+
+	// A resend is accepted as a duplicate
+	for _, expected := range []string{types.ReportStatusCreated, types.ReportStatusDuplicate} {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/v1/reports", bytes.NewReader(message))
+
+		response := types.ReportUploadResponse{}
+		if status := doRequest(t, app, req, &response); status != fiber.StatusOK {
+			t.Errorf("expected status %d, got %d", fiber.StatusOK, status)
+		}
+
+		if len(response.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(response.Results))
+		}
+		result := response.Results[0]
+		if result.Status != expected || result.Type != types.ReportTypeFailure || result.ContentHash == "" {
+			t.Errorf("expected a %s failure report result, got %+v", expected, result)
+		}
+
+		if _, err := store.FindFailureReportByHash(result.ContentHash); err != nil {
+			t.Errorf("expected the failure report to be stored: %s", err)
+		}
+	}
+
+	// Alongside aggregate reports, in a multipart upload
+	req := uploadRequest(t,
+		[]byte("valid2.xml"), readTestData(t, "valid2.xml"),
+		[]byte("failure.eml"), message,
+	)
+
+	response := types.ReportUploadResponse{}
+	if status := doRequest(t, app, req, &response); status != fiber.StatusOK {
+		t.Errorf("expected status %d, got %d", fiber.StatusOK, status)
+	}
+
+	results := []string{}
+	for _, result := range response.Results {
+		results = append(results, result.Name+" "+result.Type+" "+result.Status)
+	}
+	expected := []string{"valid2.xml aggregate created", "failure.eml failure duplicate"}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected results %v, got %v", expected, results)
+	}
+}
+
+func TestHandleCreateReportsTLSReport(t *testing.T) {
+	store := newTestStorage(t)
+	app := newTestApp(store)
+	report := readTestData(t, "tlsrpt.json")
+
+	// A resend is accepted as a duplicate
+	for _, expected := range []string{types.ReportStatusCreated, types.ReportStatusDuplicate} {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/v1/reports", bytes.NewReader(gzipData(t, report)))
+
+		response := types.ReportUploadResponse{}
+		if status := doRequest(t, app, req, &response); status != fiber.StatusOK {
+			t.Errorf("expected status %d, got %d", fiber.StatusOK, status)
+		}
+
+		if len(response.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(response.Results))
+		}
+		result := response.Results[0]
+		if result.Status != expected || result.Type != types.ReportTypeTLS || result.OrgName != "Company-X" {
+			t.Errorf("expected a %s TLS report result from Company-X, got %+v", expected, result)
+		}
+
+		if _, err := store.FindTLSReportByHash(result.ContentHash); err != nil {
+			t.Errorf("expected the TLS report to be stored: %s", err)
+		}
+	}
+
+	// An invalid TLS report lists its problems, it is not read as an aggregate report
+	invalid := bytes.Replace(report, []byte(`"policy-type": "sts"`), []byte(`"policy-type": "dane"`), 1)
+	req := uploadRequest(t, []byte("invalid.json"), invalid)
+
+	response := types.ReportUploadResponse{}
+	if status := doRequest(t, app, req, &response); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", fiber.StatusUnprocessableEntity, status)
+	}
+
+	result := response.Results[0]
+	if result.Type != types.ReportTypeTLS || len(result.ValidationErrors) != 1 {
+		t.Errorf("expected an invalid TLS report with 1 validation error, got %+v", result)
+	}
+}
+
+func TestHandleCreateReportsStatus(t *testing.T) {
+	store := newTestStorage(t)
+	valid := readTestData(t, "valid4.xml")
+	malformed := readTestData(t, "malformed.xml")
+
+	raw := func(data []byte) *http.Request {
+		return httptest.NewRequest(fiber.MethodPost, "/api/v1/reports", bytes.NewReader(data))
+	}
+
+	tests := map[string]struct {
+		store    database.Storage
+		req      *http.Request
+		expected int
+	}{
+		"invalid report": {store, raw(malformed), fiber.StatusUnprocessableEntity},
+		"every file invalid": {store, uploadRequest(t,
+			[]byte("malformed.xml"), malformed,
+			[]byte("garbage.txt"), []byte("not a report"),
+		), fiber.StatusUnprocessableEntity},
+		"some files invalid": {store, uploadRequest(t,
+			[]byte("valid4.xml"), valid,
+			[]byte("malformed.xml"), malformed,
+		), fiber.StatusMultiStatus},
+		"storage failure":        {&failingStorage{store}, raw(readTestData(t, "valid5.xml")), fiber.StatusInternalServerError},
+		"unknown format":         {store, raw([]byte("not a report")), fiber.StatusUnsupportedMediaType},
+		"message without report": {store, raw([]byte("From: sales@example.com\r\nSubject: Earn money\r\n\r\nReply to earn money.\r\n")), fiber.StatusUnprocessableEntity},
+		"no report":              {store, uploadRequest(t), fiber.StatusBadRequest},
+		"invalid validation":     {store, httptest.NewRequest(fiber.MethodPost, "/api/v1/reports?validation=none", bytes.NewReader(valid)), fiber.StatusBadRequest},
+	}
+
+	for name, test := range tests {
+		var response map[string]any
+		if status := doRequest(t, newTestApp(test.store), test.req, &response); status != test.expected {
+			t.Errorf("%s: expected status %d, got %d: %v", name, test.expected, status, response)
+		}
+	}
+}
+
+// loadTestReports parses the given testdata files and stores them
+func loadTestReports(t *testing.T, store database.Storage, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		report, err := parsers.NewReport(readTestData(t, name))
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", name, err)
+		}
+
+		if err := store.CreateReport(report); err != nil {
+			t.Fatalf("failed to store %s: %s", name, err)
+		}
+	}
+}
+
+// listReportIDs lists reports with the given query string, and returns the report IDs of the page
+func listReportIDs(t *testing.T, app *fiber.App, query string) ([]string, string) {
+	t.Helper()
+
+	response := types.ReportListResponse{}
+	if status := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/api/v1/reports?"+query, nil), &response); status != fiber.StatusOK {
+		t.Fatalf("%s: expected status %d, got %d", query, fiber.StatusOK, status)
+	}
+
+	ids := []string{}
+	for _, report := range response.Reports {
+		if len(report.Records) != 0 {
+			t.Errorf("%s: expected reports to be listed without their records", query)
+		}
+		ids = append(ids, report.ReportMetadata.ReportID)
+	}
+
+	return ids, response.NextCursor
+}
+
+func TestHandleListReports(t *testing.T) {
+	store := newTestStorage(t)
+	loadTestReports(t, store, "valid1.xml", "valid2.xml", "valid3.xml", "valid4.xml", "valid5.xml")
+	app := newTestApp(store)
+
+	tests := map[string][]string{
+		// Newest first by default
+		"":                                   {"valid5reportid", "valid2reportid", "valid1reportid", "valid3reportid", "valid4reportid"},
+		"order=asc":                          {"valid4reportid", "valid3reportid", "valid1reportid", "valid2reportid", "valid5reportid"},
+		"domain=EXAMPLE.com.&org_name=Yahoo": {"valid1reportid", "valid4reportid"},
+		"domain=other.example":               {},
+		"from=2023-01-01T00:00:00Z&to=1680220800": {"valid2reportid", "valid1reportid"},
+		"has_failures=true&order=asc":             {"valid4reportid", "valid1reportid", "valid5reportid"},
+		"has_failures=false":                      {"valid2reportid", "valid3reportid"},
+		"sort=org_name&order=asc":                 {"valid2reportid", "valid1reportid", "valid4reportid", "valid3reportid", "valid5reportid"},
+	}
+
+	for query, expected := range tests {
+		ids, cursor := listReportIDs(t, app, query)
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%q: expected %v, got %v", query, expected, ids)
+		}
+		if cursor != "" {
+			t.Errorf("%q: expected no next page, got cursor %q", query, cursor)
+		}
+	}
+}
+
+func TestHandleListReportsPagination(t *testing.T) {
+	store := newTestStorage(t)
+	loadTestReports(t, store, "valid1.xml", "valid2.xml", "valid3.xml", "valid4.xml", "valid5.xml")
+	app := newTestApp(store)
+
+	expected := [][]string{{"valid4reportid", "valid3reportid"}, {"valid1reportid", "valid2reportid"}, {"valid5reportid"}}
+
+	pages := [][]string{}
+	cursor := ""
+	for len(pages) <= len(expected) {
+		ids, next := listReportIDs(t, app, "order=asc&limit=2&cursor="+url.QueryEscape(cursor))
+		pages = append(pages, ids)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+}
+
+func TestHandleListReportsInvalid(t *testing.T) {
+	app := newTestApp(newTestStorage(t))
+
+	for _, query := range []string{"from=yesterday", "to=2023-13-01", "has_failures=maybe", "conflicting=maybe", "sort=report_id", "cursor=bad"} {
+		response := types.ErrorResponse{}
+		if status := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/api/v1/reports?"+query, nil), &response); status != fiber.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, fiber.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestHandleGetReport(t *testing.T) {
+	store := newTestStorage(t)
+	loadTestReports(t, store, "valid1.xml", "valid3.xml")
+	app := newTestApp(store)
+
+	// Reports stored before content hashes were recorded have none
+	legacy, err := parsers.NewReport(readTestData(t, "valid2.xml"))
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+	legacy.ContentHash = ""
+	if err := store.CreateReport(legacy); err != nil {
+		t.Fatalf("failed to store report: %s", err)
+	}
+
+	// Another reporter using the same report ID
+	other, err := parsers.NewReport(bytes.Replace(readTestData(t, "valid1.xml"), []byte("<org_name>Yahoo"), []byte("<org_name>Other"), 1))
+	if err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+	if err := store.CreateReport(other); err != nil {
+		t.Fatalf("failed to store report: %s", err)
+	}
+
+	stored, err := store.FindReportByReportID("cisco.com", "valid3reportid")
+	if err != nil {
+		t.Fatalf("failed to find report: %s", err)
+	}
+
+	tests := map[string]struct {
+		expected int
+		reportID string
+	}{
+		stored.ContentHash:              {fiber.StatusOK, "valid3reportid"},
+		"valid3reportid":                {fiber.StatusOK, "valid3reportid"},
+		"valid2reportid":                {fiber.StatusOK, "valid2reportid"},
+		"valid1reportid?org_name=Other": {fiber.StatusOK, "valid1reportid"},
+		"valid1reportid":                {fiber.StatusConflict, ""},
+		"unknown":                       {fiber.StatusNotFound, ""},
+		"valid3reportid?org_name=Other": {fiber.StatusNotFound, ""},
+	}
+
+	for path, test := range tests {
+		report := parsers.Report{}
+		status := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/api/v1/reports/"+path, nil), &report)
+		if status != test.expected {
+			t.Errorf("%s: expected status %d, got %d", path, test.expected, status)
+		}
+
+		if test.reportID != "" && (report.ReportMetadata.ReportID != test.reportID || len(report.Records) == 0) {
+			t.Errorf("%s: expected report %s with its records, got %+v", path, test.reportID, report.ReportMetadata)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/types"
+)
+
+// HandleListTLSReports returns the stored TLS reports
+//
+// Query parameters:
+//   - domain: only the TLS reports with a policy for this domain
+func HandleListTLSReports(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		reports, err := store.FindTLSReports(c.Query("domain"))
+		if err != nil {
+			log.Errorf("Failed to find TLS reports: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to find TLS reports"})
+		}
+
+		return c.JSON(&types.TLSReportListResponse{TLSReports: reports})
+	}
+}
+
+// HandleGetTLSReport returns a single TLS report, by its content hash
+func HandleGetTLSReport(store database.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, err := store.FindTLSReportByHash(c.Params("hash"))
+		if err != nil {
+			if errors.Is(err, database.ErrReportNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(&types.ErrorResponse{Error: err.Error()})
+			}
+
+			log.Errorf("Failed to find TLS report %s: %s", c.Params("hash"), err)
+			return c.Status(fiber.StatusInternalServerError).JSON(&types.ErrorResponse{Error: "failed to find TLS report"})
+		}
+
+		return c.JSON(report)
+	}
+}
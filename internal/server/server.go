@@ -1,13 +1,20 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/attachments"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/database"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/routes"
 )
 
+// shutdownTimeout is how long the open requests are given to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 type APIServer struct {
 	host  string
 	port  int
@@ -22,11 +29,39 @@ func NewAPIServer(host string, port int, store database.Storage) *APIServer {
 	}
 }
 
-func (s *APIServer) RegisterRoutesAndStart() error {
-	app := fiber.New()
+// RegisterRoutesAndStart serves the API until the context is done, then stops
+// accepting connections and waits for the open requests to finish
+func (s *APIServer) RegisterRoutesAndStart(ctx context.Context) error {
+	app := fiber.New(fiber.Config{
+		// Compressed uploads are limited again once decompressed
+		BodyLimit: attachments.DefaultMaxDecompressedSize,
+	})
 
 	// Register routes
 	app.Get("/health", routes.HandleHealth)
 
-	return app.Listen(fmt.Sprintf("%s:%d", s.host, s.port))
+	api := app.Group("/api/v1")
+	api.Get("/reports", routes.HandleListReports(s.store))
+	api.Get("/reports/:id", routes.HandleGetReport(s.store))
+	api.Post("/reports", routes.HandleCreateReports(s.store))
+	api.Get("/failure-reports", routes.HandleListFailureReports(s.store))
+	api.Get("/failure-reports/:hash", routes.HandleGetFailureReport(s.store))
+	api.Get("/tls-reports", routes.HandleListTLSReports(s.store))
+	api.Get("/tls-reports/:hash", routes.HandleGetTLSReport(s.store))
+
+	served := make(chan error, 1)
+	go func() { served <- app.Listen(fmt.Sprintf("%s:%d", s.host, s.port)) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("Shutting down the API server")
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		return err
+	}
+
+	return <-served
 }
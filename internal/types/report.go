@@ -1 +1,63 @@
 package types
+
+import "github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+
+// Statuses of a report submitted through the API
+const (
+	ReportStatusCreated   = "created"
+	ReportStatusDuplicate = "duplicate"
+	// ReportStatusConflict means the report was stored, but another report of the
+	// same reporter has the same report ID with a different content
+	ReportStatusConflict = "conflict"
+	ReportStatusInvalid  = "invalid"
+	ReportStatusFailed   = "failed"
+)
+
+// Types of a report submitted through the API
+const (
+	ReportTypeAggregate = "aggregate"
+	ReportTypeFailure   = "failure"
+	ReportTypeTLS       = "tls"
+)
+
+// ReportUploadResult is the outcome of a single report of an upload
+type ReportUploadResult struct {
+	// Name of the uploaded file or archive member, if known
+	Name string `json:"name,omitempty"`
+	// Type is the type of the report, unknown for files that could not be read
+	Type        string `json:"type,omitempty"`
+	ReportID    string `json:"report_id,omitempty"`
+	OrgName     string `json:"org_name,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Records     int    `json:"records"`
+	// InvalidRecords were kept, tagged with their validation issues
+	InvalidRecords int `json:"invalid_records,omitempty"`
+	// SchemaIssues is the number of schema problems of a report kept in schema warn mode
+	SchemaIssues int    `json:"schema_issues,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	// ValidationErrors explain why an invalid report was rejected
+	ValidationErrors parsers.ValidationErrors `json:"validation_errors,omitempty"`
+}
+
+type ReportUploadResponse struct {
+	Results []ReportUploadResult `json:"results"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type ReportListResponse struct {
+	Reports    []*parsers.Report `json:"reports"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// FailureReportListResponse lists failure reports, oldest arrival date first
+type FailureReportListResponse struct {
+	FailureReports []*parsers.FailureReport `json:"failure_reports"`
+}
+
+type TLSReportListResponse struct {
+	TLSReports []*parsers.TLSReport `json:"tls_reports"`
+}
@@ -0,0 +1,18 @@
+package types
+
+import "time"
+
+// ReporterErrors groups the errors reported by a single reporting organization
+type ReporterErrors struct {
+	OrgName string          `json:"org_name"`
+	Errors  []ReporterError `json:"errors"`
+}
+
+// ReporterError is an error a reporter included in the metadata of a report
+type ReporterError struct {
+	ReportID       string    `json:"report_id"`
+	Email          string    `json:"email"`
+	DateRangeBegin time.Time `json:"date_range_begin"`
+	DateRangeEnd   time.Time `json:"date_range_end"`
+	Message        string    `json:"message"`
+}
@@ -1,27 +1,79 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2/log"
 	database_sqlite "github.com/stavros-k/go-dmarc-analyzer/internal/database/sqlite"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/inputs"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
 	"github.com/stavros-k/go-dmarc-analyzer/internal/server"
 )
 
 var directories = []string{"reports"}
 
+// Locally delivered rua mail, e.g. "/var/mail/dmarc" or "/home/dmarc/Maildir"
+var maildirs = []string{}
+var mboxes = []string{}
+
+// Leave the address empty to disable the IMAP input
+var imapConfig = inputs.IMAPConfig{
+	Address:          "",
+	TLS:              true,
+	Mailbox:          "INBOX",
+	ProcessedMailbox: "DMARC/Processed",
+	FailedMailbox:    "DMARC/Failed",
+}
+
+// Leave the address empty to disable the SMTP/LMTP receiver
+var smtpConfig = inputs.SMTPConfig{
+	Address:          "",
+	Domain:           "localhost",
+	LMTP:             false,
+	RecipientDomains: []string{},
+}
+
+// Invalid records are kept and tagged with their issues in lenient mode,
+// and reject the whole report in strict mode. Each input can be set separately.
+var validationMode = parsers.ValidationLenient
+
+// Reports can be checked against the RFC 7489 schema, to grade reporters on
+// spec compliance. Problems are stored as warnings in warn mode, and reject
+// the report in error mode.
+var schemaMode = parsers.SchemaOff
+
+// Report directories are watched for new files with inotify in notify mode,
+// and rescanned every processFileInterval in poll mode
+var fileWatchMode = inputs.WatchNotify
+
+// Number of report files processed concurrently per directory, writes to
+// the database are still made one at a time
+var fileWorkers = inputs.DefaultFileWorkers
+
 const processFileAtBoot = false
 const processFileInterval = time.Second * 30
+const processMailInterval = time.Minute * 5
 
 func main() {
+	// go-dmarc-analyzer validate <report> [<report>...]
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
 	// Create a new storage
 	store, err := database_sqlite.NewSqliteStorage("dmarc.db")
 	if err != nil {
 		panic(err)
 	}
 	// Migrate the database
-	store.Migrate()
+	if err := store.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate the database: %s", err)
+	}
 
 	inputers := []inputs.Inputer{}
 	// Create file inputer(s)
@@ -32,20 +84,103 @@ func main() {
 			log.Errorf("Failed to create provider for directory %s: %s", dir, err)
 			continue
 		}
+		p.Validation = validationMode
+		p.Schema = schemaMode
+		p.WatchMode = fileWatchMode
+		p.Workers = fileWorkers
+		inputers = append(inputers, p)
+	}
+
+	// Create maildir inputer(s)
+	for _, dir := range maildirs {
+		p, err := inputs.NewMaildirInput(dir, store)
+		if err != nil {
+			log.Errorf("Failed to create provider for maildir %s: %s", dir, err)
+			continue
+		}
+		p.Validation = validationMode
+		p.Schema = schemaMode
 		inputers = append(inputers, p)
 	}
 
+	// Create mbox inputer(s)
+	for _, file := range mboxes {
+		p, err := inputs.NewMboxInput(file, store)
+		if err != nil {
+			log.Errorf("Failed to create provider for mbox %s: %s", file, err)
+			continue
+		}
+		p.Validation = validationMode
+		p.Schema = schemaMode
+		inputers = append(inputers, p)
+	}
+
+	// Create IMAP inputer
+	if imapConfig.Address != "" {
+		p, err := inputs.NewIMAPInput(imapConfig, store)
+		if err != nil {
+			log.Errorf("Failed to create provider for imap server %s: %s", imapConfig.Address, err)
+		} else {
+			p.Validation = validationMode
+			p.Schema = schemaMode
+			inputers = append(inputers, p)
+		}
+	}
+
+	// Create SMTP/LMTP inputer
+	if smtpConfig.Address != "" {
+		p, err := inputs.NewSMTPInput(smtpConfig, store)
+		if err != nil {
+			log.Errorf("Failed to create provider for smtp listener %s: %s", smtpConfig.Address, err)
+		} else {
+			p.Validation = validationMode
+			p.Schema = schemaMode
+			inputers = append(inputers, p)
+		}
+	}
+
+	// Everything stops on SIGINT or SIGTERM, after finishing the reports being processed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Start processing
+	wg := sync.WaitGroup{}
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
 	for _, p := range inputers {
+		p := p
 		switch p.(type) {
 		case *inputs.FileInput:
 			if processFileAtBoot {
-				go p.ProcessAll()
+				run(func() { p.ProcessAll(ctx) })
 			}
-			go p.Watch(processFileInterval)
+			run(func() { p.Watch(ctx, processFileInterval) })
+		case *inputs.MaildirInput, *inputs.MboxInput:
+			run(func() { p.Watch(ctx, processFileInterval) })
+		case *inputs.IMAPInput:
+			run(func() { p.Watch(ctx, processMailInterval) })
+		case *inputs.SMTPInput:
+			// Listens until the context is done, the interval is not used
+			run(func() { p.Watch(ctx, 0) })
 		}
 	}
 
 	s := server.NewAPIServer("localhost", 8080, store)
-	s.RegisterRoutesAndStart()
+	if err := s.RegisterRoutesAndStart(ctx); err != nil {
+		log.Errorf("Failed to serve the API: %s", err)
+	}
+
+	// The inputs are stopped as well if the server failed
+	stop()
+	wg.Wait()
+
+	if err := store.Close(); err != nil {
+		log.Errorf("Failed to close the database: %s", err)
+	}
 }
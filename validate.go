@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/stavros-k/go-dmarc-analyzer/internal/attachments"
+	"github.com/stavros-k/go-dmarc-analyzer/internal/parsers"
+)
+
+// validate checks report files (xml, json, gzip or zip) in strict mode, and prints
+// every validation problem, and every schema problem as a warning.
+// It returns the exit code of the command.
+func validate(files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-dmarc-analyzer validate <report> [<report>...]")
+		return 2
+	}
+
+	code := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			code = 1
+			continue
+		}
+
+		payloads, err := attachments.Extract(file, data, attachments.DefaultLimits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			code = 1
+			continue
+		}
+
+		for _, payload := range payloads {
+			name := file
+			if payload.Name != "" && payload.Name != file {
+				name = file + ":" + payload.Name
+			}
+
+			issues, err := validatePayload(payload.Data)
+			if err != nil {
+				code = 1
+
+				errs := parsers.ValidationErrors{}
+				if !errors.As(err, &errs) {
+					fmt.Printf("%s: %s\n", name, err)
+					continue
+				}
+
+				problems := "problems"
+				if len(errs) == 1 {
+					problems = "problem"
+				}
+
+				fmt.Printf("%s: %d %s\n", name, len(errs), problems)
+				for _, validationErr := range errs {
+					fmt.Printf("  %s\n", validationErr.Error())
+				}
+				continue
+			}
+
+			fmt.Printf("%s: ok\n", name)
+			for _, issue := range issues {
+				fmt.Printf("  warning: %s\n", issue.Error())
+			}
+		}
+	}
+
+	return code
+}
+
+// validatePayload parses a single decompressed report, aggregate or TLS, and
+// returns its schema problems. TLS reports have no schema to check against.
+func validatePayload(data []byte) ([]parsers.ValidationError, error) {
+	if attachments.Detect(data) == attachments.FormatJSON {
+		_, err := parsers.NewTLSReport(data)
+		return nil, err
+	}
+
+	opts := parsers.Options{Validation: parsers.ValidationStrict, Schema: parsers.SchemaWarn}
+	report, err := parsers.NewReportWithOptions(data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return report.SchemaIssues, nil
+}
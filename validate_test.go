@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureValidate runs validate on the given files and returns its exit code and output
+func captureValidate(t *testing.T, files ...string) (int, string) {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	code := validate(files)
+	os.Stdout = stdout
+	writer.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read output: %s", err)
+	}
+
+	return code, string(output)
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	report, err := os.ReadFile(filepath.Join("testdata", "tlsrpt.json"))
+	if err != nil {
+		t.Fatalf("failed to read tlsrpt.json: %s", err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	data := bytes.Replace(report, []byte(`"policy-type": "sts"`), []byte(`"policy-type": "dane"`), 1)
+	if err := os.WriteFile(invalid, data, 0600); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+
+	tests := map[string]struct {
+		file     string
+		code     int
+		expected string
+	}{
+		"aggregate report":   {filepath.Join("testdata", "extensions.xml"), 0, ": ok\n"},
+		"tls report":         {filepath.Join("testdata", "tlsrpt.json"), 0, ": ok\n"},
+		"invalid tls report": {invalid, 1, ": 1 problem\n  policies[0].policy.policy-type: "},
+	}
+
+	for name, test := range tests {
+		code, output := captureValidate(t, test.file)
+		if code != test.code || !strings.HasPrefix(output, test.file+test.expected) {
+			t.Errorf("%s: expected exit code %d and output %q, got %d and %q", name, test.code, test.file+test.expected, code, output)
+		}
+	}
+}